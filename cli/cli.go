@@ -19,6 +19,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/lscheidler/switchctl/common"
 )
@@ -26,55 +29,253 @@ import (
 const (
 	version = "0.4"
 
-	applicationUsage   = "set application to switch"
-	debugUsage         = "debug mode"
-	debugDefault       = false
-	dryrunDefault      = false
-	dryrunUsage        = "do not execute switch"
-	environmentDefault = "production"
-	environmentUsage   = "set environment to use"
-	logfileDefault     = "logs/switchctl.log"
-	logfileUsage       = "logfile path"
-	workersDefault     = 5
-	workersUsage       = "number of workers run simultaneously"
+	CommandApprove   = "approve"
+	CommandConfig    = "config"
+	CommandPlan      = "plan"
+	CommandReconcile = "reconcile"
+	CommandServe     = "serve"
+	CommandSwitch    = "switch"
+	CommandUI        = "ui"
+	CommandUnlock    = "unlock"
+
+	applicationUsage    = "set application to switch"
+	correctDriftUsage   = "reconcile applications, which differ only on some instances"
+	atUsage             = "schedule switch at local time, e.g. 2006-01-02T15:04"
+	configUsage         = "config file, http(s) url or git::<repository>//<path>?ref=<ref> (default: SWITCHCTL_CONFIG, ./config.yml or ~/.config/switchctl/config.yml)"
+	debugUsage          = "debug mode"
+	debugDefault        = false
+	dryrunDefault       = false
+	dryrunUsage         = "do not execute switch"
+	environmentDefault  = "production"
+	environmentUsage    = "set environment to use"
+	expiresInDefault    = 24 * time.Hour
+	expiresInUsage      = "validity of plans written by the plan and reconcile commands"
+	followDefault       = false
+	followUsage         = "stream output of switch commands, implies --progress plain instead of fancy"
+	inUsage             = "schedule switch in duration, e.g. 2h"
+	intervalDefault     = 5 * time.Minute
+	intervalUsage       = "interval to reconcile with --watch"
+	junitUsage          = "write junit report to file"
+	keyUsage            = "comment or SHA256 fingerprint of the ssh-agent key to approve plans or sign config files with (default: first key)"
+	listenUsage         = "listen address of the http api (default: server.listen of config or 127.0.0.1:8080, random local port for ui)"
+	logfileDefault      = "logs/switchctl.log"
+	keepRunsDefault     = 20
+	keepRunsUsage       = "number of run directories to keep (0 keeps all)"
+	logfileUsage        = "logfile path"
+	noColorDefault      = false
+	noColorUsage        = "disable colored output (also set by NO_COLOR environment variable)"
+	overrideFreezeUsage = "override active freeze windows with justification"
+	planUsage           = "switch applications of an approved plan file"
+	prefetchAfterUsage  = "re-run prefetch before a scheduled switch, if the wait exceeded duration (0 disables)"
+	progressUsage       = "progress output: fancy, plain or none (default: fancy for terminals, plain otherwise)"
+	rundirDefault       = "logs/runs"
+	rundirUsage         = "base path for run directories with command transcripts"
+	watchUsage          = "reconcile repeatedly with interval"
+	workersDefault      = 5
+	workersUsage        = "number of workers run simultaneously"
+	yesDefault          = false
+	yesUsage            = "switch without interactive confirmation"
 )
 
+// configCommands are the subcommands of the config command
+var configCommands = map[string]bool{
+	"explain":  true,
+	"sign":     true,
+	"validate": true,
+}
+
+var scheduleLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+var commands = map[string]string{
+	CommandApprove:   "approve a plan file: approve <plan.json>",
+	CommandConfig:    "check config file: config validate [<config.yml>], config explain -e <environment> -a <application> or config sign [<config.yml>]",
+	CommandPlan:      "write a plan file for approval: plan <plan.json>",
+	CommandReconcile: "reconcile versions with a desired state file: reconcile <desired.yml>",
+	CommandServe:     "serve http api to submit and monitor deployments",
+	CommandSwitch:    "switch applications (default)",
+	CommandUI:        "serve local web ui to compose, review and watch releases",
+	CommandUnlock:    "remove deploy locks of applications",
+}
+
 type Arguments struct {
-	Applications common.Applications
-	Debug        bool
-	Dryrun       bool
-	Environment  string
-	Logfile      string
-	Workers      int
+	Command        string
+	Arguments      []string
+	Applications   common.Applications
+	At             string
+	Config         string
+	CorrectDrift   bool
+	Debug          bool
+	Dryrun         bool
+	Environment    string
+	ExpiresIn      time.Duration
+	Follow         bool
+	In             time.Duration
+	Interval       time.Duration
+	Junit          string
+	KeepRuns       int
+	Key            string
+	Listen         string
+	Logfile        string
+	NoColor        bool
+	OverrideFreeze string
+	Plan           string
+	PrefetchAfter  time.Duration
+	Progress       string
+	Rundir         string
+	Schedule       time.Time
+	Watch          bool
+	Workers        int
+	Yes            bool
 }
 
 func ParseArguments() *Arguments {
 	args := Arguments{}
 
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s (%s):\n", os.Args[0], version)
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s (%s): %s [<command>] [options]\n\nCommands:\n", os.Args[0], version, os.Args[0])
+		names := []string{}
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-10s %s\n", name, commands[name])
+		}
+		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
 		flag.PrintDefaults()
 	}
 
 	flag.Var(&args.Applications, "application", applicationUsage)
 	flag.Var(&args.Applications, "a", applicationUsage)
+	flag.StringVar(&args.At, "at", "", atUsage)
+	flag.StringVar(&args.Config, "config", "", configUsage)
+	flag.StringVar(&args.Config, "c", "", configUsage)
+	flag.BoolVar(&args.CorrectDrift, "correct-drift", false, correctDriftUsage)
 	flag.StringVar(&args.Environment, "environment", environmentDefault, environmentUsage)
 	flag.StringVar(&args.Environment, "e", environmentDefault, environmentUsage)
 	flag.BoolVar(&args.Debug, "debug", debugDefault, debugUsage)
 	flag.BoolVar(&args.Debug, "d", debugDefault, debugUsage)
 	flag.BoolVar(&args.Dryrun, "dryrun", dryrunDefault, dryrunUsage)
 	flag.BoolVar(&args.Dryrun, "n", dryrunDefault, dryrunUsage)
+	flag.DurationVar(&args.ExpiresIn, "expires-in", expiresInDefault, expiresInUsage)
+	flag.BoolVar(&args.Follow, "follow", followDefault, followUsage)
+	flag.DurationVar(&args.In, "in", 0, inUsage)
+	flag.DurationVar(&args.Interval, "interval", intervalDefault, intervalUsage)
+	flag.StringVar(&args.Junit, "junit", "", junitUsage)
+	flag.IntVar(&args.KeepRuns, "keep-runs", keepRunsDefault, keepRunsUsage)
+	flag.StringVar(&args.Key, "key", "", keyUsage)
+	flag.StringVar(&args.Listen, "listen", "", listenUsage)
 	flag.StringVar(&args.Logfile, "logfile", logfileDefault, logfileUsage)
 	flag.StringVar(&args.Logfile, "l", logfileDefault, logfileUsage)
+	flag.BoolVar(&args.NoColor, "no-color", noColorDefault, noColorUsage)
+	flag.StringVar(&args.OverrideFreeze, "override-freeze", "", overrideFreezeUsage)
+	flag.StringVar(&args.Plan, "plan", "", planUsage)
+	flag.DurationVar(&args.PrefetchAfter, "prefetch-after", 0, prefetchAfterUsage)
+	flag.StringVar(&args.Progress, "progress", "", progressUsage)
+	flag.StringVar(&args.Rundir, "rundir", rundirDefault, rundirUsage)
+	flag.BoolVar(&args.Watch, "watch", false, watchUsage)
 	flag.IntVar(&args.Workers, "workers", workersDefault, workersUsage)
 	flag.IntVar(&args.Workers, "w", workersDefault, workersUsage)
+	flag.BoolVar(&args.Yes, "yes", yesDefault, yesUsage)
+	flag.BoolVar(&args.Yes, "y", yesDefault, yesUsage)
 
-	flag.Parse()
+	arguments := os.Args[1:]
+	args.Command = CommandSwitch
+	if len(arguments) > 0 && !strings.HasPrefix(arguments[0], "-") {
+		args.Command = arguments[0]
+		arguments = arguments[1:]
+	}
+	// options and arguments can be mixed, e.g. approve plan.json --key alice
+	for {
+		flag.CommandLine.Parse(arguments)
+		arguments = flag.Args()
+		if len(arguments) == 0 {
+			break
+		}
+		args.Arguments = append(args.Arguments, arguments[0])
+		arguments = arguments[1:]
+	}
 
 	err := 0
-	if len(args.Applications) == 0 {
+	if _, ok := commands[args.Command]; !ok {
+		err++
+		fmt.Printf("Unknown command %s\n", args.Command)
+	}
+
+	switch args.Command {
+	case CommandApprove, CommandPlan:
+		if len(args.Arguments) != 1 {
+			err++
+			fmt.Printf("Command %s requires exactly one plan file\n", args.Command)
+		}
+	case CommandConfig:
+		if len(args.Arguments) == 0 || !configCommands[args.Arguments[0]] {
+			err++
+			fmt.Println("Command config requires one of the subcommands explain, sign or validate")
+		}
+	case CommandReconcile:
+		if len(args.Arguments) != 1 {
+			err++
+			fmt.Println("Command reconcile requires exactly one desired state file")
+		}
+		if args.Interval <= 0 {
+			err++
+			fmt.Println("Option --interval must be positive")
+		}
+	}
+
+	switch {
+	case args.Command == CommandApprove:
+		// applications are read from the plan file
+	case args.Command == CommandConfig && (len(args.Arguments) == 0 || args.Arguments[0] != "explain"):
+		// applications are only required by explain
+	case args.Command == CommandServe || args.Command == CommandUI:
+		// applications are submitted via the http api
+	case args.Command == CommandReconcile:
+		// applications are read from the desired state file
+	case args.Command == CommandSwitch && args.Plan != "":
+		if len(args.Applications) > 0 {
+			err++
+			fmt.Println("Option -a, --application cannot be used with --plan")
+		}
+	case len(args.Applications) == 0:
 		err++
 		fmt.Println("Option -a, --application must be set")
+	case args.Command == CommandSwitch || args.Command == CommandPlan:
+		for _, application := range args.Applications {
+			if application.Version == "" {
+				err++
+				fmt.Printf("Option -a, --application must be in format <application>:<version> in \"%s\"\n", application.Name)
+			}
+		}
+	}
+
+	err += parseSchedule(&args)
+
+	interactive := IsTerminal(os.Stdout)
+	switch args.Progress {
+	case "":
+		if interactive {
+			args.Progress = "fancy"
+		} else {
+			args.Progress = "plain"
+		}
+	case "fancy", "plain", "none":
+	default:
+		err++
+		fmt.Println("Option --progress must be one of fancy, plain or none")
+	}
+	// streamed output would be overwritten by the redraws of the fancy view
+	if args.Follow && args.Progress == "fancy" {
+		args.Progress = "plain"
+	}
+
+	if os.Getenv("NO_COLOR") != "" || !interactive {
+		args.NoColor = true
 	}
 
 	if err > 0 {
@@ -84,6 +285,48 @@ func ParseArguments() *Arguments {
 	return &args
 }
 
+// parseSchedule sets the schedule of a switch from the at or in option
+func parseSchedule(args *Arguments) int {
+	if args.At == "" && args.In == 0 {
+		return 0
+	} else if args.Command != CommandSwitch {
+		fmt.Println("Options --at and --in can only be used with the switch command")
+		return 1
+	} else if args.At != "" && args.In != 0 {
+		fmt.Println("Options --at and --in cannot be used together")
+		return 1
+	}
+
+	if args.In != 0 {
+		args.Schedule = time.Now().Add(args.In)
+	} else {
+		for _, layout := range scheduleLayouts {
+			if schedule, err := time.ParseInLocation(layout, args.At, time.Local); err == nil {
+				args.Schedule = schedule
+				break
+			}
+		}
+		if args.Schedule.IsZero() {
+			fmt.Printf("Option --at must be in format %s\n", strings.Join(scheduleLayouts, " or "))
+			return 1
+		}
+	}
+
+	if !args.Schedule.After(time.Now()) {
+		fmt.Printf("Scheduled time %s is in the past\n", args.Schedule.Format(time.RFC3339))
+		return 1
+	}
+	return 0
+}
+
+// IsTerminal returns true, if file is a character device, e.g. a tty
+func IsTerminal(file *os.File) bool {
+	if stat, err := file.Stat(); err == nil {
+		return stat.Mode()&os.ModeCharDevice != 0
+	}
+	return false
+}
+
 func checkArgument(arg string, message string) int {
 	if arg == "" {
 		fmt.Println(message)
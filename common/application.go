@@ -16,19 +16,16 @@
 package common
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log"
 	"os/user"
-	"regexp"
 	"strings"
-	"text/template"
 
 	"go.uber.org/zap"
 
 	"github.com/lscheidler/switchctl/conf"
-	"github.com/lscheidler/switchctl/dns"
+	"github.com/lscheidler/switchctl/trace"
 )
 
 type Application struct {
@@ -39,6 +36,10 @@ type Application struct {
 	FailedInstances     []*Instance
 
 	Errors []*Error
+
+	span *trace.Span
+	// instances are all instances of application, whose spans are finished with the span of application
+	instances []*Instance
 }
 
 type Error struct {
@@ -63,66 +64,71 @@ func (application *Application) Load(slog *zap.SugaredLogger, config *conf.Confi
 	} else {
 		return application.Prefetch(slog, environment)
 	}
-	return nil
+}
+
+// Trace records spans of application as children of span
+func (application *Application) Trace(span *trace.Span) {
+	application.span = span
+	span.SetAttribute("application", application.Name)
+	span.SetAttribute("version", application.Version)
+}
+
+// FinishTrace finishes the span of application and the spans of its instances, which weren't finished,
+// because they weren't switched
+func (application *Application) FinishTrace(err error) {
+	for _, instance := range application.instances {
+		instance.skipTrace()
+	}
+	application.span.SetError(err)
+	application.span.Finish()
 }
 
 func (application *Application) GetInstances(slog *zap.SugaredLogger, conf *conf.Config, environment string, dryrun bool) error {
-	for _, entry := range conf.Entries {
-		var applicationAlias *string
-		applicationFound := false
-		environmentFound := false
-
-		subexpNames := map[string]string{}
-		for _, applicationS := range entry.Applications {
-			applicationRegexp := regexp.MustCompile(applicationS.Regexp)
-
-			if application.Name == applicationS.Name {
-				applicationFound = true
-				break
-			} else if applicationS.Alias != nil && application.Name == *applicationS.Alias {
-				applicationFound = true
-				applicationAlias = applicationS.Alias
-				break
-			} else if matched := applicationRegexp.MatchString(application.Name); strings.Compare(applicationS.Regexp, "") != 0 && matched {
-				applicationFound = true
-				for _, name := range applicationRegexp.SubexpNames() {
-					if name != "" {
-						subexpNames[name] = applicationRegexp.ReplaceAllString(application.Name, fmt.Sprintf("${%s}", name))
-					}
-				}
-				break
-			}
-		}
-		for _, cEnvironment := range entry.Environments {
-			if environment == cEnvironment {
-				environmentFound = true
-				break
+	span := application.span.StartSpan("GetInstances")
+	span.SetAttribute("environment", environment)
+
+	err := application.getInstances(slog, conf, environment, dryrun)
+
+	span.SetAttribute("instances", len(application.SuccessfulInstances))
+	span.SetAttribute("failed_instances", len(application.FailedInstances))
+	span.SetError(err)
+	span.Finish()
+	return err
+}
+
+func (application *Application) getInstances(slog *zap.SugaredLogger, conf *conf.Config, environment string, dryrun bool) error {
+	matches, err := Resolve(conf, environment, application.Name)
+	if err != nil {
+		application.Errors = append(application.Errors, &Error{Message: err.Error()})
+		return err
+	}
+
+	unresolved := false
+	for _, match := range matches {
+		for _, hostname := range match.Hostnames {
+			if hostname.Error != nil && hostname.Hostname == "" {
+				unresolved = true
+				application.Errors = append(application.Errors, &Error{Message: fmt.Sprintf("instance %d: %v", hostname.InstanceNumber, hostname.Error)})
+				continue
 			}
-		}
 
-		if applicationFound && environmentFound {
-			for _, instance := range entry.Instances {
-				for i := 1; i <= instance.NumberOfInstances; i++ {
-					instanceNumber := i
-					if instance.ReverseOrder {
-						instanceNumber = instance.NumberOfInstances - (i - 1)
-					}
-
-					t := template.Must(template.New("instance").Parse(instance.Template))
-					var instance bytes.Buffer
-					if applicationAlias == nil {
-						t.Execute(&instance, &templateData{Application: application.Name, Environment: environment, InstanceNumber: instanceNumber, SubexpNames: subexpNames})
-					} else {
-						t.Execute(&instance, &templateData{Application: *applicationAlias, Environment: environment, InstanceNumber: instanceNumber, SubexpNames: subexpNames})
-					}
-
-					if dns.Check(instance.String()) {
-						application.SuccessfulInstances = append(application.SuccessfulInstances, NewInstance(slog, instance.String(), "22", getUsername(), dryrun))
-					}
-				}
+			newInstance := NewInstance(slog, hostname.Hostname, hostname.Port, hostname.User, dryrun)
+			newInstance.address = hostname.Address
+			newInstance.Trace(application.span.StartSpan("instance"))
+			application.instances = append(application.instances, newInstance)
+			if hostname.Error != nil {
+				unresolved = true
+				newInstance.Unresolved(hostname.Error)
+				application.FailedInstances = append(application.FailedInstances, newInstance)
+				application.Errors = append(application.Errors, &Error{Message: hostname.Hostname + ": " + hostname.Error.Error()})
+			} else {
+				application.SuccessfulInstances = append(application.SuccessfulInstances, newInstance)
 			}
 		}
 	}
+	if unresolved && strings.Compare(environment, "staging") != 0 {
+		return errors.New(application.Name + ": hostnames cannot be resolved")
+	}
 
 	instances := application.SuccessfulInstances
 	application.SuccessfulInstances = application.SuccessfulInstances[:0]
@@ -132,6 +138,7 @@ func (application *Application) GetInstances(slog *zap.SugaredLogger, conf *conf
 			application.SuccessfulInstances = append(application.SuccessfulInstances, instance)
 			instance.GetVersion(application.Name)
 		} else {
+			instance.FinishTrace(err)
 			application.FailedInstances = append(application.FailedInstances, instance)
 			application.Errors = append(application.Errors, &Error{Message: instance.Hostname() + ": " + err.Error()})
 			if strings.Compare(environment, "staging") != 0 {
@@ -182,6 +189,7 @@ func (application *Application) Prefetch(slog *zap.SugaredLogger, environment st
 				message := instance.hostname + ": Failed to prefetch artifact " + application.Name + " (" + application.Version + ")"
 				application.Errors = append(application.Errors, &Error{Message: message, Command: command})
 				application.FailedInstances = append(application.FailedInstances, instance)
+				instance.FinishTrace(command.Error)
 
 				if strings.Compare(environment, "staging") != 0 {
 					return command.Error
@@ -214,9 +222,15 @@ type templateData struct {
 	Environment    string
 	InstanceNumber int
 	SubexpNames    map[string]string
+	Variables      map[string]string
+
+	// Hostname and Labels are only set for user and port templates
+	Hostname string
+	Labels   map[string]string
 }
 
-func getUsername() string {
+// CurrentUsername returns the name of the local user
+func CurrentUsername() string {
 	u, err := user.Current()
 	if err != nil {
 		// TODO error message
@@ -237,13 +251,14 @@ func (i *Applications) String() string {
 // Set is the method to set the flag value, part of the flag.Value interface.
 // Set's argument is a string to be parsed to set the flag.
 // It's a comma-separated list, so we split it.
+// The version is optional, commands, which require it, have to check it.
 func (i *Applications) Set(value string) error {
 	for _, t := range strings.Split(value, ",") {
 		arr := strings.SplitN(t, ":", 2)
 		if len(arr) == 2 {
 			*i = append(*i, NewApplication(arr[0], arr[1]))
 		} else {
-			log.Fatal("Option -a, --application must be in format <application>:<version> in \"", t, "\"")
+			*i = append(*i, NewApplication(arr[0], ""))
 		}
 	}
 	return nil
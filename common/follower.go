@@ -0,0 +1,102 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package common
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Follower streams the output of remote commands line by line to out,
+// lines of parallel commands are not interleaved
+type Follower struct {
+	out     io.Writer
+	mutex   sync.Mutex
+	enabled int32
+}
+
+func NewFollower(out io.Writer, enabled bool) *Follower {
+	follower := &Follower{out: out}
+	if enabled {
+		follower.enabled = 1
+	}
+	return follower
+}
+
+func (follower *Follower) Enabled() bool {
+	return atomic.LoadInt32(&follower.enabled) == 1
+}
+
+// Toggle enables or disables streaming and returns the new state
+func (follower *Follower) Toggle() bool {
+	for {
+		enabled := atomic.LoadInt32(&follower.enabled)
+		if atomic.CompareAndSwapInt32(&follower.enabled, enabled, 1-enabled) {
+			return enabled == 0
+		}
+	}
+}
+
+// Writer returns a writer, which prefixes every line with prefix
+func (follower *Follower) Writer(prefix string) *LineWriter {
+	return &LineWriter{follower: follower, prefix: []byte(prefix)}
+}
+
+func (follower *Follower) writeLine(prefix []byte, line []byte) {
+	if !follower.Enabled() {
+		return
+	}
+
+	buf := make([]byte, 0, len(prefix)+len(line)+1)
+	buf = append(append(buf, prefix...), line...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+
+	follower.mutex.Lock()
+	defer follower.mutex.Unlock()
+	follower.out.Write(buf)
+}
+
+// LineWriter buffers written data and passes complete lines to its follower
+type LineWriter struct {
+	follower *Follower
+	prefix   []byte
+	buf      []byte
+}
+
+func (writer *LineWriter) Write(p []byte) (int, error) {
+	writer.buf = append(writer.buf, p...)
+	for {
+		i := bytes.IndexByte(writer.buf, '\n')
+		if i < 0 {
+			break
+		}
+		writer.follower.writeLine(writer.prefix, writer.buf[:i+1])
+		writer.buf = writer.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes a remaining incomplete line to its follower
+func (writer *LineWriter) Flush() {
+	if len(writer.buf) > 0 {
+		writer.follower.writeLine(writer.prefix, writer.buf)
+		writer.buf = nil
+	}
+}
@@ -20,33 +20,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"go.uber.org/zap"
 
-	"github.com/lscheidler/switchctl/dns"
 	"github.com/lscheidler/switchctl/ssh"
+	"github.com/lscheidler/switchctl/trace"
 )
 
 type Instance struct {
 	hostname string
+	address  string
 	port     string
 	username string
 
-	connected      bool
-	currentVersion *Version
-	dns            bool
-	ssh            *ssh.Ssh
-	dryrun         bool
+	connected       bool
+	connectDuration time.Duration
+	connectError    error
+	currentVersion  *Version
+	dns             bool
+	ssh             *ssh.Ssh
+	dryrun          bool
+	follower        *Follower
+	followPrefix    string
 
 	Commands []*Command
 	Errors   []*Error
 
 	slog *zap.SugaredLogger
+	span *trace.Span
 }
 
+const (
+	PhaseConnect  = "connect"
+	PhaseVersion  = "version"
+	PhasePrefetch = "prefetch"
+	PhaseSwitch   = "switch"
+	PhaseLock     = "lock"
+)
+
 type Command struct {
 	Command     string
 	Description string
+	Phase       string
 
 	Stdout       *bytes.Buffer
 	StdoutWriter io.Writer
@@ -54,7 +70,13 @@ type Command struct {
 	StderrWriter io.Writer
 	Combined     *bytes.Buffer
 
+	Started  time.Time
+	Finished time.Time
+
 	Error error
+
+	lineWriters []*LineWriter
+	span        *trace.Span
 }
 
 type Version struct {
@@ -75,28 +97,70 @@ func NewInstance(slog *zap.SugaredLogger, hostname string, port string, username
 	}
 }
 
+// Trace records spans of instance as children of span
+func (instance *Instance) Trace(span *trace.Span) {
+	instance.span = span
+	span.SetAttribute("hostname", instance.hostname)
+}
+
+// FinishTrace finishes the span of instance, when it completed or failed
+func (instance *Instance) FinishTrace(err error) {
+	instance.span.SetError(err)
+	instance.span.Finish()
+}
+
+// skipTrace finishes the span of instance, if it is not finished, because instance wasn't switched
+func (instance *Instance) skipTrace() {
+	if !instance.span.Finished() {
+		instance.span.SetAttribute("skipped", true)
+		instance.span.Finish()
+	}
+}
+
 func (instance *Instance) Connect() error {
-	if dns.Check(instance.hostname) {
-		instance.dns = true
-
-		instance.ssh = ssh.New(
-			instance.hostname,
-			instance.username,
-			instance.port,
-		)
-		if err := instance.ssh.Connect(); err != nil {
-			instance.connected = false
-			instance.Errors = append(instance.Errors, &Error{Message: err.Error()})
-			return err
-		} else {
-			instance.connected = true
-		}
+	start := time.Now()
+	span := instance.span.StartSpan("connect")
+	span.SetAttribute("hostname", instance.hostname)
+	span.SetAttribute("port", instance.port)
+	span.SetAttribute("username", instance.username)
+	defer func() {
+		instance.connectDuration = time.Since(start)
+		span.SetError(instance.connectError)
+		span.SetAttribute("connected", instance.connected)
+		span.Finish()
+	}()
+
+	// hostnames are looked up by Resolve
+	instance.dns = true
+
+	address := instance.hostname
+	if instance.address != "" {
+		address = instance.address
+	}
+	instance.ssh = ssh.New(
+		address,
+		instance.username,
+		instance.port,
+	)
+	if err := instance.ssh.Connect(); err != nil {
+		instance.connected = false
+		instance.connectError = err
+		instance.Errors = append(instance.Errors, &Error{Message: err.Error()})
+		return err
 	} else {
-		instance.dns = false
+		instance.connected = true
 	}
 	return nil
 }
 
+// Unresolved marks instance as failed, because its hostname cannot be resolved
+func (instance *Instance) Unresolved(err error) {
+	instance.dns = false
+	instance.connectError = err
+	instance.Errors = append(instance.Errors, &Error{Message: err.Error()})
+	instance.FinishTrace(err)
+}
+
 func (instance *Instance) Close() {
 	if instance.ssh != nil {
 		instance.ssh.Close()
@@ -107,6 +171,14 @@ func (instance *Instance) Connected() bool {
 	return instance.connected
 }
 
+func (instance *Instance) ConnectDuration() time.Duration {
+	return instance.connectDuration
+}
+
+func (instance *Instance) ConnectError() error {
+	return instance.connectError
+}
+
 func (instance *Instance) CurrentVersion() *Version {
 	return instance.currentVersion
 }
@@ -115,7 +187,17 @@ func (instance *Instance) Dns() bool {
 	return instance.dns
 }
 
-func (instance *Instance) NewCommand(command string, description string) *Command {
+// Command returns the last executed command of phase or nil
+func (instance *Instance) Command(phase string) *Command {
+	for i := len(instance.Commands) - 1; i >= 0; i-- {
+		if instance.Commands[i].Phase == phase {
+			return instance.Commands[i]
+		}
+	}
+	return nil
+}
+
+func (instance *Instance) NewCommand(phase string, command string, description string) *Command {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 	combined := &bytes.Buffer{}
@@ -123,20 +205,58 @@ func (instance *Instance) NewCommand(command string, description string) *Comman
 	commandStruct := Command{
 		Command:      command,
 		Description:  description,
+		Phase:        phase,
 		Stdout:       stdout,
 		StdoutWriter: io.MultiWriter(stdout, combined),
 		Stderr:       stderr,
 		StderrWriter: io.MultiWriter(stderr, combined),
 		Combined:     combined,
 	}
+	if instance.follower != nil {
+		stdoutLines := instance.follower.Writer(instance.followPrefix)
+		stderrLines := instance.follower.Writer(instance.followPrefix)
+		commandStruct.StdoutWriter = io.MultiWriter(commandStruct.StdoutWriter, stdoutLines)
+		commandStruct.StderrWriter = io.MultiWriter(commandStruct.StderrWriter, stderrLines)
+		commandStruct.lineWriters = []*LineWriter{stdoutLines, stderrLines}
+	}
 	instance.Commands = append(instance.Commands, &commandStruct)
 	return &commandStruct
 }
 
-func (instance *Instance) GetVersion(application string) *Command {
-	command := instance.NewCommand("switch -i -a "+application, "get version information")
+// Execute executes command on instance
+func (instance *Instance) Execute(phase string, command string, description string) *Command {
+	commandStruct := instance.NewCommand(phase, command, description)
+	commandStruct.Error = instance.execute(commandStruct)
+	return commandStruct
+}
+
+// ExitStatus returns the exit status of command, -1 if it is unknown
+func (command *Command) ExitStatus() int {
+	return ssh.ExitStatus(command.Error)
+}
+
+func (instance *Instance) execute(command *Command) error {
+	command.span = instance.span.StartSpan(command.Phase)
+	command.span.SetAttribute("hostname", instance.hostname)
+	command.span.SetAttribute("command", command.Command)
 
+	command.Started = time.Now()
 	err := instance.ssh.Execute(command.Command, command.StdoutWriter, command.StderrWriter)
+	command.Finished = time.Now()
+
+	command.span.SetAttribute("exit_status", ssh.ExitStatus(err))
+	command.span.SetError(err)
+	command.span.Finish()
+	for _, lineWriter := range command.lineWriters {
+		lineWriter.Flush()
+	}
+	return err
+}
+
+func (instance *Instance) GetVersion(application string) *Command {
+	command := instance.NewCommand(PhaseVersion, "switch -i -a "+application, "get version information")
+
+	err := instance.execute(command)
 	if err != nil {
 		command.Error = err
 		instance.Errors = append(instance.Errors, &Error{Message: "Failed to retrieve version information"})
@@ -149,19 +269,28 @@ func (instance *Instance) GetVersion(application string) *Command {
 			instance.slog.Warn("Cannot unmarshal data: %v", err)
 		} else {
 			instance.currentVersion = &version
+			instance.span.SetAttribute("current_version", version.CurrentVersion)
+			command.span.SetAttribute("current_version", version.CurrentVersion)
 		}
 	}
 
 	return command
 }
 
+// Follow streams the output of following commands to follower, prefixed with prefix
+func (instance *Instance) Follow(follower *Follower, prefix string) {
+	instance.follower = follower
+	instance.followPrefix = prefix
+}
+
 func (instance *Instance) Hostname() string {
 	return instance.hostname
 }
 
 func (instance *Instance) Prefetch(application string, version string) *Command {
-	command := instance.NewCommand("switch -a "+application+" -v "+version+" --prefetch", "prefetch artifact")
-	err := instance.ssh.Execute(command.Command, command.StdoutWriter, command.StderrWriter)
+	command := instance.NewCommand(PhasePrefetch, "switch -a "+application+" -v "+version+" --prefetch", "prefetch artifact")
+	err := instance.execute(command)
+	command.span.SetAttribute("version", version)
 	if err != nil {
 		command.Error = err
 		instance.Errors = append(instance.Errors, &Error{Message: "Failed to prefetch artifact"})
@@ -179,9 +308,11 @@ func (instance *Instance) Switch(application string, version string) *Command {
 	}
 
 	instance.slog.Info(instance.hostname + ": " + cmd)
-	command := instance.NewCommand(cmd, "switch application")
+	command := instance.NewCommand(PhaseSwitch, cmd, "switch application")
 	//command := instance.NewCommand("/home/lscheidler/fail", "switch application")
-	err := instance.ssh.Execute(command.Command, command.StdoutWriter, command.StderrWriter)
+	err := instance.execute(command)
+	command.span.SetAttribute("version", version)
+	command.span.SetAttribute("dryrun", instance.dryrun)
 	if err != nil {
 		command.Error = err
 		instance.Errors = append(instance.Errors, &Error{Message: "Failed to switch"})
@@ -0,0 +1,359 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/discovery"
+	"github.com/lscheidler/switchctl/dns"
+)
+
+const (
+	commandTimeout = 30 * time.Second
+
+	MatchName   = "name"
+	MatchAlias  = "alias"
+	MatchRegexp = "regexp"
+)
+
+// EntryMatch is an entry of the config, which matches an application
+type EntryMatch struct {
+	Index       int
+	Entry       *conf.ConfigEntry
+	MatchedBy   string
+	Pattern     string
+	SubexpNames map[string]string
+
+	// Variables of the environment and the entry, which are available in instance templates
+	Variables map[string]string
+
+	// Environment is true, if the entry matches the environment as well, only then hostnames are resolved
+	Environment bool
+	Hostnames   []*Hostname
+}
+
+// Hostname is a rendered instance template
+type Hostname struct {
+	Template       *conf.Instance
+	InstanceNumber int
+	Hostname       string
+	User           string
+	Port           string
+	Addresses      []string
+	Labels         map[string]string
+	Error          error
+
+	// Address is connected by ssh, it is the first address, if the environment has its own nameservers
+	Address string
+
+	// Discovery is the source of discovered hostnames, e.g. srv _ssh._tcp.app1.example.com
+	Discovery string
+}
+
+// Resolve returns the entries of config, which match application, and resolves the hostnames of the entries,
+// which match environment as well
+func Resolve(config *conf.Config, environment string, application string) ([]*EntryMatch, error) {
+	var matches []*EntryMatch
+	for index, entry := range config.Entries {
+		match, err := matchEntry(entry, application)
+		if err != nil {
+			return nil, sourceError(entry, err)
+		} else if match == nil {
+			continue
+		}
+		match.Index = index
+
+		for _, cEnvironment := range entry.Environments {
+			if environment == cEnvironment {
+				match.Environment = true
+				break
+			}
+		}
+
+		if match.Environment {
+			if err := match.resolve(config, application, environment); err != nil {
+				return nil, sourceError(entry, err)
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// sourceError prefixes err with the config file, which defines entry
+func sourceError(entry *conf.ConfigEntry, err error) error {
+	if entry.Source == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %v", entry.Source, err)
+}
+
+// matchEntry returns, how the first matching application of entry matches application, or nil
+func matchEntry(entry *conf.ConfigEntry, application string) (*EntryMatch, error) {
+	for _, applicationS := range entry.Applications {
+		applicationRegexp, err := regexp.Compile(applicationS.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %v", applicationS.Regexp, err)
+		}
+
+		if application == applicationS.Name {
+			return &EntryMatch{Entry: entry, MatchedBy: MatchName, Pattern: applicationS.Name}, nil
+		} else if applicationS.Alias != nil && application == *applicationS.Alias {
+			return &EntryMatch{Entry: entry, MatchedBy: MatchAlias, Pattern: *applicationS.Alias}, nil
+		} else if applicationS.Regexp != "" && applicationRegexp.MatchString(application) {
+			match := &EntryMatch{Entry: entry, MatchedBy: MatchRegexp, Pattern: applicationS.Regexp, SubexpNames: map[string]string{}}
+			for _, name := range applicationRegexp.SubexpNames() {
+				if name != "" {
+					match.SubexpNames[name] = applicationRegexp.ReplaceAllString(application, fmt.Sprintf("${%s}", name))
+				}
+			}
+			return match, nil
+		}
+	}
+	return nil, nil
+}
+
+// resolve renders the instance templates of the entry in switch order and looks up the hostnames
+func (match *EntryMatch) resolve(config *conf.Config, application string, environment string) error {
+	subexpNames := match.SubexpNames
+	if subexpNames == nil {
+		subexpNames = map[string]string{}
+	}
+
+	variables := map[string]string{}
+	resolver := dns.New(nil)
+	if env, ok := config.Environments[environment]; ok && env != nil {
+		for name, value := range env.Variables {
+			variables[name] = value
+		}
+		resolver = dns.New(env.DNS)
+	}
+	for name, value := range match.Entry.Variables {
+		variables[name] = value
+	}
+	match.Variables = variables
+
+	for index := range match.Entry.Instances {
+		instance := &match.Entry.Instances[index]
+		if err := instance.Check(); err != nil {
+			return err
+		}
+
+		data := templateData{Application: application, Environment: environment, SubexpNames: subexpNames, Variables: variables}
+		hostnames, err := instanceHostnames(match.Entry, instance, data, resolver)
+		if err != nil {
+			return err
+		}
+
+		userTemplate, err := conf.ParseTemplate("user", instance.User)
+		if err != nil {
+			return fmt.Errorf("invalid user template %q: %v", instance.User, err)
+		}
+		portTemplate, err := conf.ParseTemplate("port", instance.Port)
+		if err != nil {
+			return fmt.Errorf("invalid port template %q: %v", instance.Port, err)
+		}
+
+		for _, hostname := range hostnames {
+			if hostname.Error == nil {
+				data.InstanceNumber = hostname.InstanceNumber
+				data.Hostname = hostname.Hostname
+				data.Labels = hostname.Labels
+				hostname.Error = executeUserAndPort(userTemplate, portTemplate, &data, hostname)
+			}
+			if hostname.Error == nil {
+				hostname.lookup(resolver)
+			}
+			match.Hostnames = append(match.Hostnames, hostname)
+		}
+	}
+	return nil
+}
+
+// instanceHostnames returns the rendered or discovered hostnames of instance in switch order
+func instanceHostnames(entry *conf.ConfigEntry, instance *conf.Instance, data templateData, resolver *dns.Resolver) ([]*Hostname, error) {
+	var hostnames []*Hostname
+	if instance.Discovered() {
+		source, hosts, err := discover(entry, instance, &data, resolver)
+		if err != nil {
+			return nil, err
+		}
+		for i, host := range hosts {
+			hostnames = append(hostnames, &Hostname{Template: instance, InstanceNumber: i + 1, Hostname: host.Hostname, User: host.User, Port: host.Port, Labels: host.Labels, Discovery: source})
+		}
+		if instance.ReverseOrder {
+			for i, j := 0, len(hostnames)-1; i < j; i, j = i+1, j-1 {
+				hostnames[i], hostnames[j] = hostnames[j], hostnames[i]
+			}
+		}
+		return hostnames, nil
+	}
+
+	numbers, err := instance.Numbers(data.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := []*template.Template{}
+	for _, text := range append([]string{instance.Template}, instance.Hosts...) {
+		t, err := conf.ParseTemplate("instance", text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q: %v", text, err)
+		}
+		templates = append(templates, t)
+	}
+
+	for _, instanceNumber := range numbers {
+		t := templates[0]
+		if len(instance.Hosts) > 0 {
+			t = templates[instanceNumber]
+		}
+
+		hostname := &Hostname{Template: instance, InstanceNumber: instanceNumber}
+		data.InstanceNumber = instanceNumber
+		hostname.Hostname, hostname.Error = execute(t, &data)
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames, nil
+}
+
+// discover renders the DNS name or inventory of instance and returns the source and the discovered hosts
+func discover(entry *conf.ConfigEntry, instance *conf.Instance, data *templateData, resolver *dns.Resolver) (string, []*discovery.Host, error) {
+	switch {
+	case instance.SRV != "":
+		name, err := render("srv", instance.SRV, data)
+		if err != nil {
+			return "", nil, err
+		}
+		return lookup("srv "+name, func() ([]*discovery.Host, error) { return discovery.SRV(resolver, name) })
+	case instance.Addresses != "":
+		name, err := render("addresses", instance.Addresses, data)
+		if err != nil {
+			return "", nil, err
+		}
+		return lookup("addresses "+name, func() ([]*discovery.Host, error) { return discovery.Addresses(resolver, name) })
+	case instance.Command != nil:
+		t, err := conf.ParseCommandTemplate("command", instance.Command.Command)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid command template %q: %v", instance.Command.Command, err)
+		}
+		command, err := execute(t, data)
+		if err != nil {
+			return "", nil, err
+		}
+		timeout := instance.Command.Timeout
+		if timeout == 0 {
+			timeout = commandTimeout
+		}
+		environment := map[string]string{"SWITCHCTL_APPLICATION": data.Application, "SWITCHCTL_ENVIRONMENT": data.Environment}
+		return lookup("command "+command, func() ([]*discovery.Host, error) { return discovery.Command(command, timeout, environment) })
+	default:
+		file, err := render("inventory file", instance.Inventory.File, data)
+		if err != nil {
+			return "", nil, err
+		}
+		group, err := render("inventory group", instance.Inventory.Group, data)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(file) && entry.Directory != "" {
+			file = filepath.Join(entry.Directory, file)
+		}
+		return lookup("inventory "+file+" "+group, func() ([]*discovery.Host, error) { return discovery.Inventory(file, group) })
+	}
+}
+
+// lookup looks up the addresses of hostname with resolver and sets the address, which is connected by ssh
+func (hostname *Hostname) lookup(resolver *dns.Resolver) {
+	name, addresses, err := resolver.Lookup(hostname.Hostname)
+	if err != nil {
+		hostname.Error = err
+		return
+	}
+
+	hostname.Hostname, hostname.Addresses, hostname.Address = name, addresses, name
+	if resolver.Custom() && len(addresses) > 0 {
+		// IPv4 addresses are preferred, IPv6 addresses are joined with the port in brackets by ssh
+		hostname.Address = addresses[0]
+		for _, address := range addresses {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				hostname.Address = address
+				break
+			}
+		}
+	}
+}
+
+// lookup returns source and the hosts of discover, errors are prefixed with source
+func lookup(source string, discover func() ([]*discovery.Host, error)) (string, []*discovery.Host, error) {
+	hosts, err := discover()
+	if err != nil {
+		return source, nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return source, hosts, nil
+}
+
+// render parses and executes the template text of kind
+func render(kind string, text string, data *templateData) (string, error) {
+	t, err := conf.ParseTemplate(kind, text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template %q: %v", kind, text, err)
+	}
+	return execute(t, data)
+}
+
+// executeUserAndPort renders the user and port templates of hostname, empty results are the discovered user and
+// port or the local user and port 22
+func executeUserAndPort(userTemplate *template.Template, portTemplate *template.Template, data *templateData, hostname *Hostname) error {
+	user, err := execute(userTemplate, data)
+	if err != nil {
+		return err
+	} else if user != "" {
+		hostname.User = user
+	} else if hostname.User == "" {
+		hostname.User = CurrentUsername()
+	}
+
+	port, err := execute(portTemplate, data)
+	if err != nil {
+		return err
+	} else if port != "" {
+		hostname.Port = port
+	} else if hostname.Port == "" {
+		hostname.Port = "22"
+	}
+	if _, err := strconv.ParseUint(hostname.Port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", hostname.Port)
+	}
+	return nil
+}
+
+func execute(t *template.Template, data *templateData) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
@@ -16,49 +16,234 @@
 package conf
 
 import (
-	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
+const configEnvironmentVariable = "SWITCHCTL_CONFIG"
+
 type Config struct {
-	Entries []*ConfigEntry
+	Include       []string        `yaml:"include"`
+	Entries       []*ConfigEntry  `yaml:"entries"`
+	Notifications []*Notification `yaml:"notifications"`
+	Metrics       *Metrics        `yaml:"metrics"`
+	Tracing       *Tracing        `yaml:"tracing"`
+	Lock          *Lock           `yaml:"lock"`
+	Freezes       []*Freeze       `yaml:"freezes"`
+	Access        []*AccessRule   `yaml:"access"`
+	Approvals     []*Approval     `yaml:"approvals"`
+	Server        *Server         `yaml:"server"`
+	Reconcile     []*Reconcile    `yaml:"reconcile"`
+
+	// Environments defines variables of instance templates per environment
+	Environments map[string]*Environment `yaml:"environments"`
+
+	// Source is the location and revision of the loaded config file
+	Source *Source `yaml:"-"`
 }
 
 type ConfigEntry struct {
-	Applications []Application `yaml:applications`
-	Environments []string      `yaml:environments`
-	Instances    []Instance    `yaml:instances`
+	Applications []Application `yaml:"applications"`
+	Environments []string      `yaml:"environments"`
+	Instances    []Instance    `yaml:"instances"`
+
+	// Variables of instance templates, which override variables of the environment
+	Variables map[string]string `yaml:"variables"`
+
+	// Source is the config file, which defines the entry, and Directory the local directory of the config file
+	Source    string `yaml:"-"`
+	Directory string `yaml:"-"`
 }
 
+// Instance defines the hostnames of an entry with template and numberOfInstances, optionally per environment,
+// with template and instanceNumbers, e.g. 1-4,7,9-10, with a list of hosts or discovers them
 type Instance struct {
 	NumberOfInstances int    `yaml:"numberOfInstances"`
-	Template          string `yaml:template`
+	Template          string `yaml:"template"`
 	ReverseOrder      bool   `yaml:"reverseInstanceOrder"`
+
+	NumberOfInstancesPerEnvironment map[string]int `yaml:"numberOfInstancesPerEnvironment"`
+	InstanceNumbers                 string         `yaml:"instanceNumbers"`
+	Hosts                           []string       `yaml:"hosts"`
+
+	// SRV and Addresses are templates of DNS names, whose SRV or A and AAAA records are the instances
+	SRV       string `yaml:"srv"`
+	Addresses string `yaml:"addresses"`
+
+	// Inventory selects the instances from groups of an ansible inventory
+	Inventory *Inventory `yaml:"inventory"`
+
+	// Command discovers the instances with an external command
+	Command *Command `yaml:"command"`
+
+	// User and Port are templates of the ssh user and port, default are the local user and 22
+	User string `yaml:"user"`
+	Port string `yaml:"port"`
+}
+
+// Inventory is an ansible inventory file in INI or YAML format and a pattern of its groups, both are templates.
+// A relative file is relative to the directory of the config file.
+type Inventory struct {
+	File  string `yaml:"file"`
+	Group string `yaml:"group"`
+}
+
+// Command is a template of a shell command, which prints the instances as JSON list, and its timeout
+type Command struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Environment defines variables of instance templates, e.g. a short code of the environment, and DNS settings
+type Environment struct {
+	Variables map[string]string `yaml:"variables"`
+	DNS       *DNS              `yaml:"dns"`
+}
+
+// DNS configures the lookup of hostnames, Skip disables the lookup, e.g. for hosts only reachable via a bastion
+type DNS struct {
+	Nameservers []string      `yaml:"nameservers"`
+	Search      []string      `yaml:"search"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Skip        bool          `yaml:"skip"`
 }
 
 type Application struct {
-	Regexp string  `yaml:regexp`
-	Name   string  `yaml:name`
-	Alias  *string `yaml:user,omitempty`
-}
-
-func LoadConfig() *Config {
-	var conf []*ConfigEntry
-	if filename := findConfigFile(); filename != nil {
-		dat, err := ioutil.ReadFile(*filename)
-		err = yaml.Unmarshal(dat, &conf)
-		if err != nil {
-			log.Fatalf("cannot unmarshal data: %v", err)
-		}
-	} else {
-		log.Println("No config file found")
+	Regexp string  `yaml:"regexp"`
+	Name   string  `yaml:"name"`
+	Alias  *string `yaml:"alias,omitempty"`
+}
+
+type Notification struct {
+	Name         string            `yaml:"name"`
+	URL          string            `yaml:"url"`
+	Format       string            `yaml:"format"`
+	Template     string            `yaml:"template"`
+	Headers      map[string]string `yaml:"headers"`
+	Events       []string          `yaml:"events"`
+	Environments []string          `yaml:"environments"`
+	Timeout      time.Duration     `yaml:"timeout"`
+
+	// Retries is nil, if it is not set, 0 disables retries
+	Retries *int `yaml:"retries"`
+}
+
+type Metrics struct {
+	Textfile    string        `yaml:"textfile"`
+	State       string        `yaml:"state"`
+	Pushgateway string        `yaml:"pushgateway"`
+	Job         string        `yaml:"job"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+type Tracing struct {
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	File     string            `yaml:"file"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+type Lock struct {
+	Disabled  bool          `yaml:"disabled"`
+	Directory string        `yaml:"directory"`
+	TTL       time.Duration `yaml:"ttl"`
+}
+
+// Freeze is a recurring (cron) or absolute (from, to) window, in which switching is refused
+type Freeze struct {
+	Environments []string `yaml:"environments"`
+	Applications []string `yaml:"applications"`
+	Reason       string   `yaml:"reason"`
+	Cron         string   `yaml:"cron"`
+	From         string   `yaml:"from"`
+	To           string   `yaml:"to"`
+	Timezone     string   `yaml:"timezone"`
+}
+
+// AccessRule restricts switching applications in environments
+type AccessRule struct {
+	Environments []string `yaml:"environments"`
+	Applications []string `yaml:"applications"`
+	Users        []string `yaml:"users"`
+	Groups       []string `yaml:"groups"`
+	Confirmation string   `yaml:"confirmation"`
+	DryrunOnly   bool     `yaml:"dryrunOnly"`
+}
+
+// Approval requires a plan with signatures of approvers to switch applications in environments
+type Approval struct {
+	Environments []string    `yaml:"environments"`
+	Applications []string    `yaml:"applications"`
+	Required     int         `yaml:"required"`
+	Approvers    []*Approver `yaml:"approvers"`
+}
+
+type Approver struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+// Server configures the http api of the serve command
+type Server struct {
+	Listen  string   `yaml:"listen"`
+	Tokens  []*Token `yaml:"tokens"`
+	History int      `yaml:"history"`
+}
+
+// Token authenticates a client of the http api as bearer token
+type Token struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// Reconcile sets the mode to apply differences to a desired state file in environments
+type Reconcile struct {
+	Environments []string `yaml:"environments"`
+	Mode         string   `yaml:"mode"`
+}
+
+// UnmarshalYAML supports the config format with a list of entries only
+func (config *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entries []*ConfigEntry
+	if err := unmarshal(&entries); err == nil {
+		config.Entries = entries
+		return nil
 	}
-	return &Config{Entries: conf}
+
+	type plain Config
+	return unmarshal((*plain)(config))
+}
+
+// LoadConfig loads the config file location or, if it is empty, the config file of SWITCHCTL_CONFIG or the default
+// paths, with its includes and the conf.d directory
+func LoadConfig(location string) *Config {
+	config, err := Load(Filename(location))
+	if err != nil {
+		log.Fatalf("cannot load config: %v", err)
+	}
+	return config
+}
+
+// Filename returns filename, if it is set, otherwise the config file of SWITCHCTL_CONFIG or the first existing
+// default config file or an empty string
+func Filename(filename string) string {
+	if filename != "" {
+		return filename
+	} else if filename := os.Getenv(configEnvironmentVariable); filename != "" {
+		return filename
+	} else if filename := findConfigFile(); filename != nil {
+		return *filename
+	}
+	return ""
+}
+
+// Directory returns the conf.d directory, whose files are merged after the config file
+func Directory() string {
+	usr, _ := user.Current()
+	return filepath.Join(usr.HomeDir, ".config/switchctl/conf.d")
 }
 
 func findConfigFile() *string {
@@ -72,3 +257,17 @@ func findConfigFile() *string {
 	}
 	return nil
 }
+
+// Matches returns true, if list is empty, which matches every value, or contains value. It is used for the
+// environments, applications and events of rules.
+func Matches(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
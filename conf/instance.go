@@ -0,0 +1,154 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxInstanceNumbers limits the instance numbers of a range, e.g. a typo like 1-999999999
+const maxInstanceNumbers = 10000
+
+// Numbers returns the instance numbers of instance in environment in switch order. The instance numbers are
+// 1 to the number of hosts, the numbers of instanceNumbers or 1 to numberOfInstances of the environment.
+func (instance *Instance) Numbers(environment string) ([]int, error) {
+	var numbers []int
+	switch {
+	case len(instance.Hosts) > 0:
+		for i := 1; i <= len(instance.Hosts); i++ {
+			numbers = append(numbers, i)
+		}
+	case instance.InstanceNumbers != "":
+		var err error
+		if numbers, err = ParseRange(instance.InstanceNumbers); err != nil {
+			return nil, err
+		}
+	default:
+		count := instance.NumberOfInstances
+		if n, ok := instance.NumberOfInstancesPerEnvironment[environment]; ok {
+			count = n
+		}
+		for i := 1; i <= count; i++ {
+			numbers = append(numbers, i)
+		}
+	}
+
+	if instance.ReverseOrder {
+		for i, j := 0, len(numbers)-1; i < j; i, j = i+1, j-1 {
+			numbers[i], numbers[j] = numbers[j], numbers[i]
+		}
+	}
+	return numbers, nil
+}
+
+// Discovered returns true, if the hosts of instance are discovered instead of rendered from templates
+func (instance *Instance) Discovered() bool {
+	return instance.SRV != "" || instance.Addresses != "" || instance.Inventory != nil || instance.Command != nil
+}
+
+// Check returns an error, if not exactly one of hosts, instanceNumbers, numberOfInstances, srv, addresses,
+// inventory or command is used
+func (instance *Instance) Check() error {
+	forms := 0
+	for _, discovery := range []string{instance.SRV, instance.Addresses} {
+		if discovery != "" {
+			forms++
+		}
+	}
+	if instance.Inventory != nil {
+		forms++
+		if instance.Inventory.File == "" || instance.Inventory.Group == "" {
+			return errors.New("file and group of inventory must be set")
+		}
+	}
+	if instance.Command != nil {
+		forms++
+		if instance.Command.Command == "" {
+			return errors.New("command of command must be set")
+		} else if instance.Command.Timeout < 0 {
+			return errors.New("timeout of command must not be negative")
+		}
+	}
+	if len(instance.Hosts) > 0 {
+		forms++
+	}
+	if instance.InstanceNumbers != "" {
+		forms++
+		if _, err := ParseRange(instance.InstanceNumbers); err != nil {
+			return err
+		}
+	}
+	if instance.NumberOfInstances > 0 || len(instance.NumberOfInstancesPerEnvironment) > 0 {
+		forms++
+		for environment, n := range instance.NumberOfInstancesPerEnvironment {
+			if n < 0 {
+				return fmt.Errorf("numberOfInstancesPerEnvironment of %s must not be negative", environment)
+			}
+		}
+	}
+
+	if forms == 0 && instance.Template != "" {
+		// before instanceNumbers and hosts were added, such templates silently had no instances
+		return fmt.Errorf("numberOfInstances, instanceNumbers or hosts must be set for template %q, e.g. numberOfInstances: 1 for a single instance", instance.Template)
+	} else if forms == 0 {
+		return errors.New("one of hosts, instanceNumbers, numberOfInstances, srv, addresses, inventory or command must be set")
+	} else if forms > 1 {
+		return errors.New("only one of hosts, instanceNumbers, numberOfInstances, srv, addresses, inventory or command can be set")
+	} else if instance.Discovered() && instance.Template != "" {
+		return errors.New("template cannot be used with srv, addresses, inventory or command")
+	} else if len(instance.Hosts) == 0 && !instance.Discovered() && instance.Template == "" {
+		return errors.New("template must be set")
+	} else if len(instance.Hosts) > 0 && instance.Template != "" {
+		return errors.New("template cannot be used with hosts")
+	}
+	return nil
+}
+
+// ParseRange parses comma-separated instance numbers and ranges, e.g. 1-4,7,9-10
+func ParseRange(s string) ([]int, error) {
+	var numbers []int
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		start, end := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			start, end = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+
+		first, err := strconv.Atoi(start)
+		if err != nil || first < 1 {
+			return nil, fmt.Errorf("invalid instance number %q in %q", start, s)
+		}
+		last, err := strconv.Atoi(end)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid range %q in %q", part, s)
+		} else if last-first >= maxInstanceNumbers-len(numbers) {
+			return nil, fmt.Errorf("%q contains more than %d instance numbers", s, maxInstanceNumbers)
+		}
+
+		for n := first; n <= last; n++ {
+			if seen[n] {
+				return nil, fmt.Errorf("instance number %d is repeated in %q", n, s)
+			}
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil
+}
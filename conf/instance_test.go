@@ -0,0 +1,120 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected []int
+		err      string
+	}{
+		{s: "1", expected: []int{1}},
+		{s: "1-4,7,9-10", expected: []int{1, 2, 3, 4, 7, 9, 10}},
+		{s: " 3 - 5 , 1 ", expected: []int{3, 4, 5, 1}},
+		{s: "2-2", expected: []int{2}},
+		{s: "1-10000", expected: sequence(1, 10000)},
+		{s: "0", err: "invalid instance number"},
+		{s: "-3", err: "invalid instance number"},
+		{s: "a-3", err: "invalid instance number"},
+		{s: "1,,2", err: "invalid instance number"},
+		{s: "3-1", err: "invalid range"},
+		{s: "1-b", err: "invalid range"},
+		{s: "1-3,2", err: "instance number 2 is repeated"},
+		{s: "1-10001", err: "more than 10000 instance numbers"},
+		{s: "1-999999999", err: "more than 10000 instance numbers"},
+		{s: "1-5000,5001-10001", err: "more than 10000 instance numbers"},
+	}
+
+	for _, test := range tests {
+		numbers, err := ParseRange(test.s)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ParseRange(%q) returned error %v, expected %q", test.s, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRange(%q) returned error: %v", test.s, err)
+		} else if !reflect.DeepEqual(numbers, test.expected) {
+			t.Errorf("ParseRange(%q) = %v, expected %v", test.s, numbers, test.expected)
+		}
+	}
+}
+
+func TestInstanceCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance Instance
+		err      string
+	}{
+		{name: "numberOfInstances", instance: Instance{NumberOfInstances: 2, Template: "app-{{ .InstanceNumber }}"}},
+		{name: "numberOfInstancesPerEnvironment", instance: Instance{NumberOfInstancesPerEnvironment: map[string]int{"staging": 1}, Template: "app"}},
+		{name: "instanceNumbers", instance: Instance{InstanceNumbers: "1-3", Template: "app-{{ .InstanceNumber }}"}},
+		{name: "hosts", instance: Instance{Hosts: []string{"a", "b"}}},
+		{name: "srv", instance: Instance{SRV: "_ssh._tcp.app"}},
+		{name: "addresses", instance: Instance{Addresses: "app.example.com"}},
+		{name: "inventory", instance: Instance{Inventory: &Inventory{File: "hosts", Group: "web"}}},
+		{name: "command", instance: Instance{Command: &Command{Command: "cmdb", Timeout: time.Second}}},
+
+		{name: "nothing", instance: Instance{}, err: "one of hosts, instanceNumbers, numberOfInstances, srv, addresses, inventory or command must be set"},
+		{name: "template without count", instance: Instance{Template: "app"}, err: `numberOfInstances, instanceNumbers or hosts must be set for template "app", e.g. numberOfInstances: 1`},
+		{name: "hosts and numberOfInstances", instance: Instance{Hosts: []string{"a"}, NumberOfInstances: 1}, err: "only one of"},
+		{name: "srv and addresses", instance: Instance{SRV: "a", Addresses: "b"}, err: "only one of"},
+		{name: "instanceNumbers and command", instance: Instance{InstanceNumbers: "1", Command: &Command{Command: "cmdb"}}, err: "only one of"},
+		{name: "invalid instanceNumbers", instance: Instance{InstanceNumbers: "3-1", Template: "app"}, err: "invalid range"},
+		{name: "negative count", instance: Instance{NumberOfInstancesPerEnvironment: map[string]int{"staging": -1}, Template: "app"}, err: "must not be negative"},
+		{name: "inventory without group", instance: Instance{Inventory: &Inventory{File: "hosts"}}, err: "file and group of inventory must be set"},
+		{name: "empty command", instance: Instance{Command: &Command{}}, err: "command of command must be set"},
+		{name: "negative timeout", instance: Instance{Command: &Command{Command: "cmdb", Timeout: -time.Second}}, err: "timeout of command must not be negative"},
+		{name: "template with srv", instance: Instance{SRV: "a", Template: "app"}, err: "template cannot be used with srv"},
+		{name: "missing template", instance: Instance{NumberOfInstances: 1}, err: "template must be set"},
+		{name: "template with hosts", instance: Instance{Hosts: []string{"a"}, Template: "app"}, err: "template cannot be used with hosts"},
+	}
+
+	for _, test := range tests {
+		err := test.instance.Check()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: Check() returned error: %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: Check() returned error %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestInstanceNumbers(t *testing.T) {
+	instance := &Instance{NumberOfInstances: 3, NumberOfInstancesPerEnvironment: map[string]int{"staging": 1}, ReverseOrder: true}
+	if numbers, _ := instance.Numbers("production"); !reflect.DeepEqual(numbers, []int{3, 2, 1}) {
+		t.Errorf("Numbers(production) = %v, expected [3 2 1]", numbers)
+	}
+	if numbers, _ := instance.Numbers("staging"); !reflect.DeepEqual(numbers, []int{1}) {
+		t.Errorf("Numbers(staging) = %v, expected [1]", numbers)
+	}
+}
+
+func sequence(first int, last int) []int {
+	var numbers []int
+	for n := first; n <= last; n++ {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
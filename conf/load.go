@@ -0,0 +1,207 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loader merges config files in the order they are loaded
+type loader struct {
+	config   *Config
+	includes bool
+	loaded   map[string]bool
+	sources  map[string]string
+
+	// keys are the trusted keys, which must sign every loaded file, if the config file is remote
+	keys []string
+}
+
+// Load loads the config file of location (see Fetch), the files of its includes and the files of the conf.d
+// directory in this order. Entries and lists are appended, single sections must only be defined once.
+// If a remote config file is verified with trusted keys, its includes and the files of the conf.d directory
+// must be signed by one of the trusted keys as well.
+func Load(location string) (*Config, error) {
+	l := &loader{
+		config:   &Config{},
+		includes: true,
+		loaded:   map[string]bool{},
+		sources:  map[string]string{},
+	}
+
+	if location != "" {
+		source, err := Fetch(location)
+		if err != nil {
+			return nil, err
+		}
+		l.config.Source = source
+		if source.Remote {
+			if l.keys, err = trustedKeys(); err != nil {
+				return nil, err
+			}
+		}
+		// includes of http config files would be relative to the cache directory
+		l.includes = !source.Remote || strings.HasPrefix(location, gitPrefix)
+		if err := l.load(source.Filename); err != nil {
+			return nil, err
+		}
+		l.includes = true
+	}
+
+	files, err := DirectoryFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := l.load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if location == "" && len(files) == 0 {
+		log.Println("No config file found")
+	}
+	return l.config, nil
+}
+
+// DirectoryFiles returns the sorted *.yml files of the conf.d directory
+func DirectoryFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(Directory(), "*.yml"))
+	sort.Strings(files)
+	return files, err
+}
+
+// Includes returns the files, which match the include patterns of the config file filename,
+// relative patterns are relative to the directory of filename
+func Includes(filename string, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(filename), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", pattern, err)
+		} else if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			// a pattern without meta characters must exist
+			return nil, fmt.Errorf("include %s: no such file", pattern)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func (l *loader) load(filename string) error {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if l.loaded[absolute] {
+		return nil
+	}
+	l.loaded[absolute] = true
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	// the remote config file itself is verified by Fetch
+	if len(l.keys) > 0 && filename != l.config.Source.Filename {
+		signature, _ := ioutil.ReadFile(filename + SignatureSuffix)
+		if err := verify(l.keys, data, signature); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	source := filename
+	if l.config.Source != nil && filename == l.config.Source.Filename {
+		source = l.config.Source.Location
+	}
+	for _, entry := range config.Entries {
+		entry.Source = source
+		entry.Directory = filepath.Dir(filename)
+	}
+	if err := l.merge(filename, &config); err != nil {
+		return err
+	}
+
+	if len(config.Include) > 0 && !l.includes {
+		return fmt.Errorf("%s: include is not supported by http config files", filename)
+	}
+	includes, err := Includes(filename, config.Include)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, include := range includes {
+		if err := l.load(include); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *loader) merge(filename string, config *Config) error {
+	target := l.config
+	target.Entries = append(target.Entries, config.Entries...)
+	target.Notifications = append(target.Notifications, config.Notifications...)
+	target.Freezes = append(target.Freezes, config.Freezes...)
+	target.Access = append(target.Access, config.Access...)
+	target.Approvals = append(target.Approvals, config.Approvals...)
+	target.Reconcile = append(target.Reconcile, config.Reconcile...)
+
+	for name, environment := range config.Environments {
+		if source, ok := l.sources["environments."+name]; ok {
+			return fmt.Errorf("%s: environment %s is already defined in %s", filename, name, source)
+		} else if target.Environments == nil {
+			target.Environments = map[string]*Environment{}
+		}
+		l.sources["environments."+name] = filename
+		target.Environments[name] = environment
+	}
+
+	sections := []struct {
+		name    string
+		defined bool
+		set     func()
+	}{
+		{"metrics", config.Metrics != nil, func() { target.Metrics = config.Metrics }},
+		{"tracing", config.Tracing != nil, func() { target.Tracing = config.Tracing }},
+		{"lock", config.Lock != nil, func() { target.Lock = config.Lock }},
+		{"server", config.Server != nil, func() { target.Server = config.Server }},
+	}
+	for _, section := range sections {
+		if !section.defined {
+			continue
+		} else if source, ok := l.sources[section.name]; ok {
+			return fmt.Errorf("%s: %s is already defined in %s", filename, section.name, source)
+		}
+		l.sources[section.name] = filename
+		section.set()
+	}
+	return nil
+}
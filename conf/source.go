@@ -0,0 +1,385 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lscheidler/switchctl/ssh"
+)
+
+const (
+	gitPrefix = "git::"
+
+	httpTimeout = 30 * time.Second
+
+	// SignatureSuffix is appended to the config file location to get the location of its signature
+	SignatureSuffix = ".sig"
+
+	// SignatureNamespace is the SSHSIG namespace of config signatures, it differs from the namespace of plan approvals
+	SignatureNamespace = "switchctl-config"
+
+	trustedKeysEnvironmentVariable = "SWITCHCTL_TRUSTED_KEYS"
+)
+
+// Source is the location of a config file and the local file, which was read
+type Source struct {
+	Location string
+	Filename string
+	Revision string
+
+	// Remote is true for config files fetched via http(s) or git
+	Remote bool
+}
+
+// String returns the location and revision of the config file
+func (source *Source) String() string {
+	if source.Revision == "" {
+		return source.Location
+	}
+	return source.Location + "@" + source.Revision
+}
+
+// Fetch returns the source of the config file location, which is a local file, a http(s) url or a file in a git
+// repository as git::<repository>//<path>?ref=<ref>. Remote config files are cached and the cached file is used,
+// if the remote is not reachable. If trusted keys exist, remote config files must be signed by one of them.
+func Fetch(location string) (*Source, error) {
+	var source *Source
+	var signature []byte
+	var err error
+
+	switch {
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		source, signature, err = fetchHTTP(location)
+	case strings.HasPrefix(location, gitPrefix):
+		source, signature, err = fetchGit(location)
+	default:
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+		return &Source{Location: location, Filename: location, Revision: checksum(data)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := trustedKeys()
+	if err != nil {
+		return nil, err
+	} else if len(keys) > 0 {
+		data, err := ioutil.ReadFile(source.Filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := verify(keys, data, signature); err != nil {
+			return nil, fmt.Errorf("%s: %v", location, err)
+		}
+	}
+	return source, nil
+}
+
+// Sign signs the config file filename with key of ssh-agent(1) and writes the signature next to it
+func Sign(filename string, key string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	_, signature, err := ssh.AgentSign(data, key, SignatureNamespace)
+	if err != nil {
+		return "", err
+	}
+	return filename + SignatureSuffix, ioutil.WriteFile(filename+SignatureSuffix, signature, 0644)
+}
+
+// TrustedKeysFile returns the file with the public keys in authorized_keys format, which sign remote config files
+func TrustedKeysFile() string {
+	if filename := os.Getenv(trustedKeysEnvironmentVariable); filename != "" {
+		return filename
+	}
+	usr, _ := user.Current()
+	return filepath.Join(usr.HomeDir, ".config/switchctl/trusted_keys")
+}
+
+// trustedKeys returns the keys of TrustedKeysFile or nil, if it doesn't exist
+func trustedKeys() ([]string, error) {
+	data, err := ioutil.ReadFile(TrustedKeysFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// verify checks, that signature is a valid signature of data by one of keys
+func verify(keys []string, data []byte, signature []byte) error {
+	if signature == nil {
+		return errors.New("signature not found")
+	}
+
+	for _, key := range keys {
+		if ssh.Verify(key, data, SignatureNamespace, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("signature is not valid for any trusted key")
+}
+
+// fetchHTTP downloads the config file at url into the cache, unless the ETag of the cached file matches
+func fetchHTTP(url string) (*Source, []byte, error) {
+	directory, err := cacheDirectory("http")
+	if err != nil {
+		return nil, nil, err
+	}
+	filename := filepath.Join(directory, checksum([]byte(url))+".yml")
+
+	keys, err := trustedKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// with trusted keys, the signature is fetched first and a changed config is only written to the cache, if it
+	// is verified, otherwise the cached config is used
+	var signature []byte
+	var check func([]byte) error
+	if len(keys) > 0 {
+		var signatureErr error
+		signature, signatureErr = get(url + SignatureSuffix)
+		check = func(data []byte) error {
+			if signatureErr != nil {
+				return signatureErr
+			}
+			return verify(keys, data, signature)
+		}
+	}
+
+	etag, err := download(url, filename, check)
+	if err != nil {
+		if _, statErr := os.Stat(filename); statErr != nil {
+			return nil, nil, err
+		}
+		log.Printf("Failed to fetch config %s, using cached config: %v", url, err)
+		etag, _ = ioutil.ReadFile(filename + ".etag")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the cached signature is only replaced by a signature of the cached config
+	if signature != nil && verify(keys, data, signature) == nil {
+		if err := writeFile(filename+SignatureSuffix, signature); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		signature, _ = ioutil.ReadFile(filename + SignatureSuffix)
+	}
+
+	revision := strings.Trim(strings.TrimPrefix(string(etag), "W/"), `"`)
+	if revision == "" {
+		revision = checksum(data)
+	}
+	return &Source{Location: url, Filename: filename, Revision: revision, Remote: true}, signature, nil
+}
+
+// download writes url to filename and its ETag to filename.etag, if it changed and check accepts it, and returns
+// the ETag
+func download(url string, filename string, check func([]byte) error) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	etag, _ := ioutil.ReadFile(filename + ".etag")
+	if _, err := os.Stat(filename); err == nil && etag != nil {
+		request.Header.Set("If-None-Match", string(etag))
+	}
+
+	client := &http.Client{Timeout: httpTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return etag, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("%s: %s", url, response.Status)
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+	}
+	if err := writeFile(filename, data); err != nil {
+		return nil, err
+	}
+	etag = []byte(response.Header.Get("ETag"))
+	return etag, writeFile(filename+".etag", etag)
+}
+
+// get returns the content of url
+func get(url string) ([]byte, error) {
+	client := &http.Client{Timeout: httpTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// writeFile writes data to a temporary file in the directory of filename, which replaces filename
+func writeFile(filename string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// fetchGit checks out ref of a clone of the repository in the cache, the clone is updated with git fetch
+func fetchGit(location string) (*Source, []byte, error) {
+	repository, path, ref, err := parseGitLocation(location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	directory, err := cacheDirectory("git")
+	if err != nil {
+		return nil, nil, err
+	}
+	directory = filepath.Join(directory, checksum([]byte(repository)))
+
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		if _, err := git("", "clone", "--quiet", "--no-checkout", repository, directory); err != nil {
+			os.RemoveAll(directory)
+			return nil, nil, err
+		}
+	} else if _, err := git(directory, "fetch", "--quiet", "--prune", "origin"); err != nil {
+		log.Printf("Failed to fetch config repository %s, using cached repository: %v", repository, err)
+	}
+
+	revision, err := git(directory, "rev-parse", "--verify", "--quiet", "origin/"+ref+"^{commit}")
+	if err != nil {
+		if revision, err = git(directory, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+			return nil, nil, fmt.Errorf("%s: unknown ref %s", repository, ref)
+		}
+	}
+	if _, err := git(directory, "checkout", "--quiet", "--force", "--detach", revision); err != nil {
+		return nil, nil, err
+	}
+
+	filename := filepath.Join(directory, path)
+	signature, _ := ioutil.ReadFile(filename + SignatureSuffix)
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	return &Source{Location: location, Filename: filename, Revision: revision, Remote: true}, signature, nil
+}
+
+// parseGitLocation splits git::<repository>//<path>?ref=<ref>, path defaults to config.yml and ref to HEAD
+func parseGitLocation(location string) (repository string, path string, ref string, err error) {
+	repository = strings.TrimPrefix(location, gitPrefix)
+	ref = "HEAD"
+	if i := strings.LastIndex(repository, "?ref="); i >= 0 {
+		repository, ref = repository[:i], repository[i+len("?ref="):]
+	}
+	path = "config.yml"
+	if i := strings.LastIndex(repository, "//"); i >= 0 && !strings.HasSuffix(repository[:i], ":") {
+		repository, path = repository[:i], repository[i+2:]
+	}
+
+	if repository == "" || ref == "" || path == "" {
+		return "", "", "", fmt.Errorf("invalid git config location %s, expected %s<repository>//<path>?ref=<ref>", location, gitPrefix)
+	} else if filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+		return "", "", "", fmt.Errorf("invalid git config location %s, path must be relative to the repository", location)
+	}
+	return repository, path, ref, nil
+}
+
+func git(directory string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = directory
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// cacheDirectory returns and creates the directory name in the switchctl cache
+func cacheDirectory(name string) (string, error) {
+	directory, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	directory = filepath.Join(directory, "switchctl", name)
+	return directory, os.MkdirAll(directory, 0700)
+}
+
+// checksum returns the first 12 hex digits of the sha256 checksum of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
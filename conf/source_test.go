@@ -0,0 +1,187 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// setenv sets key to value until the test finishes
+func setenv(t *testing.T, key string, value string) {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// testAgent serves an ssh-agent with a new ed25519 key in SSH_AUTH_SOCK and returns its public key
+func testAgent(t *testing.T, dir string) string {
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: private}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	setenv(t, "SSH_AUTH_SOCK", listener.Addr().String())
+
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(publicKey))
+}
+
+func TestFetchHTTPVerifiesBeforeCaching(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := testAgent(t, dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "trusted_keys"), []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, trustedKeysEnvironmentVariable, filepath.Join(dir, "trusted_keys"))
+	setenv(t, "XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	setenv(t, "HOME", dir)
+
+	// sign returns content and its signature
+	sign := func(content string) (string, string) {
+		filename := filepath.Join(dir, "config.yml")
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		signatureFile, err := Sign(filename, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := ioutil.ReadFile(signatureFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content, string(signature)
+	}
+
+	var mutex sync.Mutex
+	var config, signature, etag string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch request.URL.Path {
+		case "/config.yml":
+			if request.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(config))
+		case "/config.yml" + SignatureSuffix:
+			w.Write([]byte(signature))
+		default:
+			http.NotFound(w, request)
+		}
+	}))
+	defer server.Close()
+	serve := func(c string, s string, e string) {
+		mutex.Lock()
+		config, signature, etag = c, s, e
+		mutex.Unlock()
+	}
+
+	tests := []struct {
+		name      string
+		config    string
+		signature string
+		etag      string
+		cached    string
+		etagFile  string
+	}{
+		{"signed", "version: 1\n", "", `"1"`, "version: 1\n", `"1"`},
+		{"not modified", "version: 1\n", "", `"1"`, "version: 1\n", `"1"`},
+		{"tampered", "version: 2\n", "version: 1\n", `"2"`, "version: 1\n", `"1"`},
+		{"missing signature", "version: 2\n", "-", `"2"`, "version: 1\n", `"1"`},
+		{"signed update", "version: 2\n", "", `"2"`, "version: 2\n", `"2"`},
+	}
+	for _, test := range tests {
+		content, s := sign(test.config)
+		if test.signature == "-" {
+			s = ""
+		} else if test.signature != "" {
+			_, s = sign(test.signature)
+		}
+		serve(content, s, test.etag)
+
+		source, err := Fetch(server.URL + "/config.yml")
+		if err != nil {
+			t.Errorf("%s: Fetch returned %v", test.name, err)
+			continue
+		}
+		data, _ := ioutil.ReadFile(source.Filename)
+		if string(data) != test.cached {
+			t.Errorf("%s: cached config is %q, expected %q", test.name, data, test.cached)
+		}
+		etag, _ := ioutil.ReadFile(source.Filename + ".etag")
+		if string(etag) != test.etagFile {
+			t.Errorf("%s: cached etag is %s, expected %s", test.name, etag, test.etagFile)
+		}
+		files, _ := filepath.Glob(filepath.Join(filepath.Dir(source.Filename), "*.tmp*"))
+		if len(files) > 0 {
+			t.Errorf("%s: temporary files %v are left in the cache", test.name, files)
+		}
+	}
+
+	// without a cached config, an invalid config is an error
+	serve("version: 3\n", signature, `"3"`)
+	if _, err := Fetch(server.URL + "/config.yml?other"); err == nil || !strings.Contains(err.Error(), "signature is not valid") {
+		t.Errorf("Fetch of invalid config without cache returned %v", err)
+	}
+}
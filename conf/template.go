@@ -0,0 +1,120 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateFuncs are the functions of instance templates in addition to the builtin functions of text/template
+var TemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"pad":        pad,
+	"replace":    replace,
+	"shellquote": shellquote,
+	"trimPrefix": trimPrefix,
+	"trimSuffix": trimSuffix,
+	"upper":      strings.ToUpper,
+}
+
+// ParseTemplate parses text as instance template with TemplateFuncs, missing keys are errors
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// ParseCommandTemplate parses text as template of a shell command like ParseTemplate. Values can contain
+// arbitrary characters, e.g. application names of api requests, so every action must end with shellquote.
+func ParseCommandTemplate(name string, text string) (*template.Template, error) {
+	t, err := ParseTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkShellquote(t.Tree.Root); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkShellquote returns an error, if an action in node doesn't end with shellquote
+func checkShellquote(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, n := range node.Nodes {
+			if err := checkShellquote(n); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		if len(node.Pipe.Decl) > 0 {
+			return nil
+		}
+		cmds := node.Pipe.Cmds
+		if identifier, ok := cmds[len(cmds)-1].Args[0].(*parse.IdentifierNode); ok && identifier.Ident == "shellquote" {
+			return nil
+		}
+		return fmt.Errorf("action %s must end with shellquote", node)
+	case *parse.IfNode:
+		return checkBranch(&node.BranchNode)
+	case *parse.RangeNode:
+		return checkBranch(&node.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&node.BranchNode)
+	case *parse.TemplateNode:
+		return errors.New("template actions are not supported")
+	}
+	return nil
+}
+
+func checkBranch(node *parse.BranchNode) error {
+	if err := checkShellquote(node.List); err != nil {
+		return err
+	}
+	return checkShellquote(node.ElseList)
+}
+
+// shellquote quotes value as single argument of sh(1), e.g. {{ .Application | shellquote }}
+func shellquote(value interface{}) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", `'\''`) + "'"
+}
+
+// pad pads value with leading zeros to width, e.g. {{ pad 2 .InstanceNumber }}
+func pad(width int, value interface{}) string {
+	s := fmt.Sprint(value)
+	if len(s) < width {
+		s = strings.Repeat("0", width-len(s)) + s
+	}
+	return s
+}
+
+// replace replaces all old with new in s, e.g. {{ .Application | replace "-" "" }}
+func replace(old string, new string, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+func trimPrefix(prefix string, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+func trimSuffix(suffix string, s string) string {
+	return strings.TrimSuffix(s, suffix)
+}
@@ -0,0 +1,184 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/agtorre/gocolorize"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/validate"
+)
+
+// validateConfig validates the config file given as argument or the config file, which is loaded by default,
+// with its includes and the conf.d directory
+func validateConfig(args *cli.Arguments) int {
+	cgreen := gocolorize.Colorize{Fg: gocolorize.Green}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	location := conf.Filename(args.Config)
+	if len(args.Arguments) > 1 {
+		location = args.Arguments[1]
+	}
+	if location == "" {
+		fmt.Println(cred.Paint("No config file found"))
+		return 1
+	}
+
+	source, err := conf.Fetch(location)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to read config file:"), err)
+		return 1
+	}
+	errors, err := validate.File(source.Filename)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to read config file:"), err)
+		return 1
+	}
+	for _, err := range errors {
+		fmt.Println(cred.Paint(err.Error()))
+	}
+	if len(errors) > 0 {
+		fmt.Printf("%d error(s) found\n", len(errors))
+		return 1
+	}
+	fmt.Println(cgreen.Paint(source.String() + " is valid"))
+	return 0
+}
+
+// signConfig signs the config file given as argument or the config file, which is loaded by default,
+// with a key of ssh-agent(1)
+func signConfig(args *cli.Arguments) int {
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	filename := conf.Filename(args.Config)
+	if len(args.Arguments) > 1 {
+		filename = args.Arguments[1]
+	}
+	if filename == "" {
+		fmt.Println(cred.Paint("No config file found"))
+		return 1
+	}
+
+	signature, err := conf.Sign(filename, args.Key)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to sign config file:"), err)
+		return 1
+	}
+	fmt.Println("Signature written to", signature)
+	return 0
+}
+
+// explainConfig shows, which entries of the config match the applications and which hostnames they resolve to,
+// without opening ssh connections
+func explainConfig(args *cli.Arguments, config *conf.Config) int {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+	cgreen := gocolorize.Colorize{Fg: gocolorize.Green}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	exitCode := 0
+	for _, application := range args.Applications {
+		fmt.Printf("%s in %s:\n", cyellow.Paint(application.Name), cyellow.Paint(args.Environment))
+
+		matches, err := common.Resolve(config, args.Environment, application.Name)
+		if err != nil {
+			fmt.Println(" ", cred.Paint(err.Error()))
+			exitCode = 1
+			continue
+		}
+
+		instances := 0
+		for _, match := range matches {
+			fmt.Printf("  - entry %d: matched by %s %q\n", match.Index+1, match.MatchedBy, match.Pattern)
+			if match.Entry.Source != "" {
+				fmt.Printf("    source:     %s\n", match.Entry.Source)
+			}
+			if !match.Environment {
+				fmt.Printf("    environments: %s (%s not included, skipped)\n", strings.Join(match.Entry.Environments, ", "), args.Environment)
+				continue
+			}
+			for _, name := range sortedKeys(match.SubexpNames) {
+				fmt.Printf("    subexpName: %s=%s\n", name, match.SubexpNames[name])
+			}
+			for _, name := range sortedKeys(match.Variables) {
+				fmt.Printf("    variable:   %s=%s\n", name, match.Variables[name])
+			}
+
+			count := map[*conf.Instance]int{}
+			for _, hostname := range match.Hostnames {
+				count[hostname.Template]++
+			}
+
+			var template *conf.Instance
+			for _, hostname := range match.Hostnames {
+				if hostname.Template != template {
+					template = hostname.Template
+					order := ""
+					if template.ReverseOrder {
+						order = ", reverse order"
+					}
+					switch {
+					case hostname.Discovery != "":
+						fmt.Printf("    discovery:  %s (%d instances%s)\n", hostname.Discovery, count[template], order)
+					case len(template.Hosts) > 0:
+						fmt.Printf("    hosts:      %s (%d instances%s)\n", strings.Join(template.Hosts, ", "), count[template], order)
+					default:
+						fmt.Printf("    template:   %s (%d instances%s)\n", template.Template, count[template], order)
+					}
+				}
+
+				instances++
+				if hostname.Error != nil {
+					fmt.Printf("      %d. instance %d: %s %s\n", instances, hostname.InstanceNumber, cred.Paint(hostname.Hostname), cred.Paint("failed: "+hostname.Error.Error()))
+				} else if len(hostname.Addresses) == 0 {
+					fmt.Printf("      %d. instance %d: %s (dns skipped)\n", instances, hostname.InstanceNumber, cgreen.Paint(hostname.Hostname))
+				} else {
+					fmt.Printf("      %d. instance %d: %s %s\n", instances, hostname.InstanceNumber, cgreen.Paint(hostname.Hostname), strings.Join(hostname.Addresses, ", "))
+				}
+				if hostname.Error == nil && (hostname.User != common.CurrentUsername() || hostname.Port != "22") {
+					fmt.Printf("         ssh:        %s@%s:%s\n", hostname.User, hostname.Hostname, hostname.Port)
+				}
+				for _, name := range sortedKeys(hostname.Labels) {
+					fmt.Printf("         label:      %s=%s\n", name, hostname.Labels[name])
+				}
+			}
+		}
+
+		if len(matches) == 0 {
+			fmt.Println(" ", cred.Paint("no entry matches "+application.Name))
+			exitCode = 1
+		} else if instances == 0 {
+			fmt.Println(" ", cred.Paint("no instances in "+args.Environment))
+			exitCode = 1
+		}
+		fmt.Println()
+	}
+	return exitCode
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,496 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	inventoryRange = regexp.MustCompile(`\[([0-9]+|[a-z]):([0-9]+|[a-z])(?::([0-9]+))?\]`)
+
+	inventoryHostVariables = []string{"ansible_host", "ansible_ssh_host"}
+	inventoryPortVariables = []string{"ansible_port", "ansible_ssh_port"}
+	inventoryUserVariables = []string{"ansible_user", "ansible_ssh_user"}
+)
+
+// inventory is an ansible inventory with groups in order of their definition
+type inventory struct {
+	groups    map[string]*group
+	names     []string
+	variables map[string]map[string]string
+}
+
+type group struct {
+	hosts     []string
+	children  []string
+	variables map[string]string
+}
+
+// Inventory returns the hosts of the groups of an ansible inventory file in INI or YAML format, which match pattern.
+// pattern is a list of group names or glob patterns separated by comma or colon. Hosts use ansible_host,
+// ansible_port and ansible_user of the host or its groups.
+func Inventory(filename string, pattern string) ([]*Host, error) {
+	return cached("inventory "+filename+" "+pattern, func() ([]*Host, error) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		var inv *inventory
+		switch path.Ext(filename) {
+		case ".yml", ".yaml":
+			inv, err = parseYAMLInventory(data)
+		default:
+			inv, err = parseINIInventory(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+
+		return inv.match(pattern)
+	})
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		groups:    map[string]*group{},
+		variables: map[string]map[string]string{},
+	}
+}
+
+// group returns the group name and creates it, if it doesn't exist
+func (inv *inventory) group(name string) *group {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &group{variables: map[string]string{}}
+		inv.groups[name] = g
+		inv.names = append(inv.names, name)
+	}
+	return g
+}
+
+// addHost adds host with variables to group g
+func (inv *inventory) addHost(g *group, host string, variables map[string]string) {
+	g.hosts = append(g.hosts, host)
+	if _, ok := inv.variables[host]; !ok {
+		inv.variables[host] = map[string]string{}
+	}
+	for name, value := range variables {
+		inv.variables[host][name] = value
+	}
+}
+
+// match returns the hosts of the groups, which match pattern, in order of the groups and their definition
+func (inv *inventory) match(pattern string) ([]*Host, error) {
+	var names []string
+	for _, p := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ',' || r == ':' }) {
+		p = strings.TrimSpace(p)
+		matched := false
+		for _, name := range inv.groupNames() {
+			if ok, err := path.Match(p, name); err != nil {
+				return nil, fmt.Errorf("invalid group pattern %q: %v", p, err)
+			} else if ok {
+				names = append(names, name)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no group matches %q", p)
+		}
+	}
+
+	var hosts []*Host
+	seen := map[string]bool{}
+	for _, name := range names {
+		for _, hostname := range inv.hosts(name, map[string]bool{}) {
+			if seen[hostname] {
+				continue
+			}
+			seen[hostname] = true
+			hosts = append(hosts, inv.host(hostname))
+		}
+	}
+	return hosts, nil
+}
+
+// groupNames returns all and the names of all groups in order of their definition
+func (inv *inventory) groupNames() []string {
+	names := []string{"all"}
+	for _, name := range inv.names {
+		if name != "all" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hosts returns the hosts of group name and its children, all contains all hosts
+func (inv *inventory) hosts(name string, visited map[string]bool) []string {
+	if name == "all" {
+		var hosts []string
+		for _, n := range inv.names {
+			hosts = append(hosts, inv.groups[n].hosts...)
+		}
+		return hosts
+	}
+
+	g, ok := inv.groups[name]
+	if !ok || visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	hosts := append([]string{}, g.hosts...)
+	for _, child := range g.children {
+		hosts = append(hosts, inv.hosts(child, visited)...)
+	}
+	return hosts
+}
+
+// host returns hostname with the variables of all, its groups, in order of their depth, and the host
+func (inv *inventory) host(hostname string) *Host {
+	variables := map[string]string{}
+	if all, ok := inv.groups["all"]; ok {
+		for name, value := range all.variables {
+			variables[name] = value
+		}
+	}
+
+	var groups []string
+	for _, name := range inv.names {
+		if name == "all" {
+			continue
+		}
+		for _, h := range inv.hosts(name, map[string]bool{}) {
+			if h == hostname {
+				groups = append(groups, name)
+				break
+			}
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return inv.depth(groups[i], map[string]bool{}) < inv.depth(groups[j], map[string]bool{})
+	})
+	for _, name := range groups {
+		for variable, value := range inv.groups[name].variables {
+			variables[variable] = value
+		}
+	}
+	for name, value := range inv.variables[hostname] {
+		variables[name] = value
+	}
+
+	host := &Host{Hostname: hostname, Labels: map[string]string{}}
+	for _, name := range inventoryHostVariables {
+		if value, ok := variables[name]; ok && host.Hostname == hostname {
+			host.Hostname = value
+		}
+	}
+	for _, name := range inventoryPortVariables {
+		if value, ok := variables[name]; ok && host.Port == "" {
+			host.Port = value
+		}
+	}
+	for _, name := range inventoryUserVariables {
+		if value, ok := variables[name]; ok && host.User == "" {
+			host.User = value
+		}
+	}
+	host.Labels["inventory_hostname"] = hostname
+	host.Labels["groups"] = strings.Join(groups, ",")
+	return host
+}
+
+// depth returns the longest chain of parent groups of group name
+func (inv *inventory) depth(name string, visited map[string]bool) int {
+	if visited[name] {
+		return 0
+	}
+	visited[name] = true
+	defer delete(visited, name)
+
+	depth := 0
+	for _, parent := range inv.names {
+		for _, child := range inv.groups[parent].children {
+			if child == name {
+				if d := inv.depth(parent, visited) + 1; d > depth {
+					depth = d
+				}
+			}
+		}
+	}
+	return depth
+}
+
+// parseINIInventory parses an inventory in INI format with host ranges like web[01:03].example.com
+func parseINIInventory(data []byte) (*inventory, error) {
+	inv := newInventory()
+	section, kind := inv.group("ungrouped"), ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := line[1 : len(line)-1]
+			kind = ""
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				name, kind = name[:i], name[i+1:]
+			}
+			if kind != "" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: unknown section type %q", number, kind)
+			}
+			section = inv.group(name)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			i := strings.Index(line, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("line %d: expected variable=value", number)
+			}
+			section.variables[strings.TrimSpace(line[:i])] = unquote(strings.TrimSpace(line[i+1:]))
+		case "children":
+			section.children = append(section.children, line)
+			inv.group(line)
+		default:
+			fields, err := splitShell(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			variables := map[string]string{}
+			for _, field := range fields[1:] {
+				i := strings.Index(field, "=")
+				if i < 0 {
+					return nil, fmt.Errorf("line %d: expected variable=value, got %q", number, field)
+				}
+				variables[field[:i]] = field[i+1:]
+			}
+			hosts, err := expandRange(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			for _, host := range hosts {
+				inv.addHost(section, host, variables)
+			}
+		}
+	}
+	return inv, scanner.Err()
+}
+
+// parseYAMLInventory parses an inventory in YAML format with hosts, vars and children of groups
+func parseYAMLInventory(data []byte) (*inventory, error) {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	inv := newInventory()
+	for _, item := range root {
+		if err := inv.parseYAMLGroup(fmt.Sprint(item.Key), item.Value); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+func (inv *inventory) parseYAMLGroup(name string, value interface{}) error {
+	g := inv.group(name)
+	if value == nil {
+		return nil
+	}
+	items, ok := value.(yaml.MapSlice)
+	if !ok {
+		return fmt.Errorf("group %s: expected mapping", name)
+	}
+
+	for _, item := range items {
+		switch item.Key {
+		case "hosts":
+			hosts, ok := item.Value.(yaml.MapSlice)
+			if !ok && item.Value != nil {
+				return fmt.Errorf("group %s: expected mapping of hosts", name)
+			}
+			for _, host := range hosts {
+				expanded, err := expandRange(fmt.Sprint(host.Key))
+				if err != nil {
+					return fmt.Errorf("group %s: %v", name, err)
+				}
+				for _, hostname := range expanded {
+					inv.addHost(g, hostname, yamlVariables(host.Value))
+				}
+			}
+		case "vars":
+			for variable, value := range yamlVariables(item.Value) {
+				g.variables[variable] = value
+			}
+		case "children":
+			children, ok := item.Value.(yaml.MapSlice)
+			if !ok && item.Value != nil {
+				return fmt.Errorf("group %s: expected mapping of children", name)
+			}
+			for _, child := range children {
+				g.children = append(g.children, fmt.Sprint(child.Key))
+				if err := inv.parseYAMLGroup(fmt.Sprint(child.Key), child.Value); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("group %s: unknown key %v", name, item.Key)
+		}
+	}
+	return nil
+}
+
+// yamlVariables returns the scalar values of a mapping
+func yamlVariables(value interface{}) map[string]string {
+	variables := map[string]string{}
+	if items, ok := value.(yaml.MapSlice); ok {
+		for _, item := range items {
+			variables[fmt.Sprint(item.Key)] = fmt.Sprint(item.Value)
+		}
+	}
+	return variables
+}
+
+// expandRange expands the first range of host, e.g. web[01:03] or db-[a:c], recursively
+func expandRange(host string) ([]string, error) {
+	match := inventoryRange.FindStringSubmatchIndex(host)
+	if match == nil {
+		return []string{host}, nil
+	}
+	prefix, suffix := host[:match[0]], host[match[1]:]
+	start, end := host[match[2]:match[3]], host[match[4]:match[5]]
+	step := 1
+	if match[6] >= 0 {
+		step, _ = strconv.Atoi(host[match[6]:match[7]])
+		if step < 1 {
+			return nil, fmt.Errorf("invalid step in range of %s", host)
+		}
+	}
+
+	var values []string
+	if first, err := strconv.Atoi(start); err == nil {
+		last, err := strconv.Atoi(end)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid range of %s", host)
+		}
+		for i := first; i <= last; i += step {
+			value := strconv.Itoa(i)
+			if strings.HasPrefix(start, "0") && len(value) < len(start) {
+				value = strings.Repeat("0", len(start)-len(value)) + value
+			}
+			values = append(values, value)
+		}
+	} else {
+		if len(end) != 1 || end[0] < start[0] {
+			return nil, fmt.Errorf("invalid range of %s", host)
+		}
+		for c := int(start[0]); c <= int(end[0]); c += step {
+			values = append(values, string(rune(c)))
+		}
+	}
+
+	var hosts []string
+	for _, value := range values {
+		expanded, err := expandRange(prefix + value + suffix)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// splitShell splits a host line into fields like sh(1): whitespace separates fields, single quotes preserve
+// every character, double quotes and backslashes escape whitespace and quotes,
+// e.g. ansible_ssh_common_args="-o ProxyJump=bastion", and an unquoted # at the beginning of a field starts
+// a comment, e.g. web1 ansible_port=22 # primary
+func splitShell(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '#' && quote == 0 && !inField {
+			break
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				field.WriteRune(runes[i])
+			} else {
+				field.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inField = r, true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			field.WriteRune(runes[i])
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
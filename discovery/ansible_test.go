@@ -0,0 +1,241 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandRange(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected []string
+		err      bool
+	}{
+		{host: "web.example.com", expected: []string{"web.example.com"}},
+		{host: "web[1:3].example.com", expected: []string{"web1.example.com", "web2.example.com", "web3.example.com"}},
+		{host: "web[01:03]", expected: []string{"web01", "web02", "web03"}},
+		{host: "web[08:10]", expected: []string{"web08", "web09", "web10"}},
+		{host: "web[1:6:2]", expected: []string{"web1", "web3", "web5"}},
+		{host: "db-[a:c]", expected: []string{"db-a", "db-b", "db-c"}},
+		{host: "db-[a:e:2]", expected: []string{"db-a", "db-c", "db-e"}},
+		{host: "web[1:2]-[a:b]", expected: []string{"web1-a", "web1-b", "web2-a", "web2-b"}},
+		{host: "web[3:1]", err: true},
+		{host: "web[1:3:0]", err: true},
+		{host: "db-[c:a]", err: true},
+		{host: "web[1:b]", err: true},
+	}
+
+	for _, test := range tests {
+		hosts, err := expandRange(test.host)
+		if test.err {
+			if err == nil {
+				t.Errorf("expandRange(%q) = %v, expected error", test.host, hosts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandRange(%q) returned error: %v", test.host, err)
+		} else if !reflect.DeepEqual(hosts, test.expected) {
+			t.Errorf("expandRange(%q) = %v, expected %v", test.host, hosts, test.expected)
+		}
+	}
+}
+
+func TestSplitShell(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		err      bool
+	}{
+		{line: "web1  ansible_port=2222\tansible_user=deploy", expected: []string{"web1", "ansible_port=2222", "ansible_user=deploy"}},
+		{line: `web1 ansible_ssh_common_args="-o ProxyJump=x y"`, expected: []string{"web1", "ansible_ssh_common_args=-o ProxyJump=x y"}},
+		{line: `web1 motd='it"s  here'`, expected: []string{"web1", `motd=it"s  here`}},
+		{line: `web1 motd="say \"hi\" \\ bye"`, expected: []string{"web1", `motd=say "hi" \ bye`}},
+		{line: `web1 path=a\ b`, expected: []string{"web1", "path=a b"}},
+		{line: `web1 empty=""`, expected: []string{"web1", "empty="}},
+		{line: `web1 broken="-o x`, err: true},
+		{line: "web1 ansible_port=22 # primary", expected: []string{"web1", "ansible_port=22"}},
+		{line: "web1 ansible_port=22\t#primary 'unterminated", expected: []string{"web1", "ansible_port=22"}},
+		{line: `web1 motd="# not a comment" tag='#' color=#fff path=\#x`, expected: []string{"web1", "motd=# not a comment", "tag=#", "color=#fff", "path=#x"}},
+	}
+
+	for _, test := range tests {
+		fields, err := splitShell(test.line)
+		if test.err {
+			if err == nil {
+				t.Errorf("splitShell(%q) = %q, expected error", test.line, fields)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitShell(%q) returned error: %v", test.line, err)
+		} else if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("splitShell(%q) = %q, expected %q", test.line, fields, test.expected)
+		}
+	}
+}
+
+const iniInventory = `
+# comment
+bastion.example.com
+
+[web]
+web[1:2].example.com ansible_user=web
+web3.example.com ansible_host=10.0.0.3 ansible_port=2222 ansible_ssh_common_args="-o ProxyJump=bastion -q"
+
+[db]
+db1.example.com
+
+[web:vars]
+ansible_user=deploy
+ansible_port=22
+
+[app:children]
+web
+db
+
+[app:vars]
+ansible_user=app
+ansible_port=2200
+
+[all:vars]
+ansible_user=root
+`
+
+const yamlInventory = `
+all:
+  vars:
+    ansible_user: root
+  hosts:
+    bastion.example.com:
+  children:
+    app:
+      vars:
+        ansible_user: app
+        ansible_port: 2200
+      children:
+        web:
+          vars:
+            ansible_user: deploy
+            ansible_port: 22
+          hosts:
+            web[1:2].example.com:
+              ansible_user: web
+            web3.example.com:
+              ansible_host: 10.0.0.3
+              ansible_port: 2222
+        db:
+          hosts:
+            db1.example.com:
+`
+
+func TestInventory(t *testing.T) {
+	parsers := map[string]func([]byte) (*inventory, error){
+		"ini":  parseINIInventory,
+		"yaml": parseYAMLInventory,
+	}
+	inventories := map[string]string{
+		"ini":  iniInventory,
+		"yaml": yamlInventory,
+	}
+
+	tests := []struct {
+		pattern  string
+		expected []Host
+	}{
+		// host variables override variables of web, which override variables of its parent app and all
+		{pattern: "web", expected: []Host{
+			{Hostname: "web1.example.com", User: "web", Port: "22"},
+			{Hostname: "web2.example.com", User: "web", Port: "22"},
+			{Hostname: "10.0.0.3", User: "deploy", Port: "2222"},
+		}},
+		{pattern: "db", expected: []Host{
+			{Hostname: "db1.example.com", User: "app", Port: "2200"},
+		}},
+		{pattern: "app", expected: []Host{
+			{Hostname: "web1.example.com", User: "web", Port: "22"},
+			{Hostname: "web2.example.com", User: "web", Port: "22"},
+			{Hostname: "10.0.0.3", User: "deploy", Port: "2222"},
+			{Hostname: "db1.example.com", User: "app", Port: "2200"},
+		}},
+		{pattern: "db,w*", expected: []Host{
+			{Hostname: "db1.example.com", User: "app", Port: "2200"},
+			{Hostname: "web1.example.com", User: "web", Port: "22"},
+			{Hostname: "web2.example.com", User: "web", Port: "22"},
+			{Hostname: "10.0.0.3", User: "deploy", Port: "2222"},
+		}},
+	}
+
+	for format, parse := range parsers {
+		inv, err := parse([]byte(inventories[format]))
+		if err != nil {
+			t.Fatalf("%s: failed to parse inventory: %v", format, err)
+		}
+
+		for _, test := range tests {
+			hosts, err := inv.match(test.pattern)
+			if err != nil {
+				t.Errorf("%s: match(%q) returned error: %v", format, test.pattern, err)
+				continue
+			}
+			var result []Host
+			for _, host := range hosts {
+				result = append(result, Host{Hostname: host.Hostname, User: host.User, Port: host.Port})
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("%s: match(%q) = %+v, expected %+v", format, test.pattern, result, test.expected)
+			}
+		}
+
+		if _, err := inv.match("missing"); err == nil {
+			t.Errorf("%s: match(%q) expected error", format, "missing")
+		}
+		if hosts, err := inv.match("all"); err != nil || len(hosts) != 5 {
+			t.Errorf("%s: match(%q) = %d hosts, %v, expected 5 hosts", format, "all", len(hosts), err)
+		}
+	}
+}
+
+func TestINIInventoryQuotedVariables(t *testing.T) {
+	inv, err := parseINIInventory([]byte(iniInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "-o ProxyJump=bastion -q"
+	if value := inv.variables["web3.example.com"]["ansible_ssh_common_args"]; value != expected {
+		t.Errorf("ansible_ssh_common_args = %q, expected %q", value, expected)
+	}
+
+	inv, err = parseINIInventory([]byte("[web]\nweb1 ansible_port=2222 # primary\nweb2 # secondary\n"))
+	if err != nil {
+		t.Fatalf("inline comments returned error: %v", err)
+	}
+	if !reflect.DeepEqual(inv.variables["web1"], map[string]string{"ansible_port": "2222"}) {
+		t.Errorf("variables of web1 with inline comment = %v", inv.variables["web1"])
+	}
+	if len(inv.variables["web2"]) != 0 {
+		t.Errorf("variables of web2 with inline comment = %v", inv.variables["web2"])
+	}
+
+	if _, err := parseINIInventory([]byte("[web]\nweb1 ansible_user=\"deploy\n")); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+	if _, err := parseINIInventory([]byte("[web:unknown]\nweb1\n")); err == nil {
+		t.Error("expected error for unknown section type")
+	}
+}
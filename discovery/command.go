@@ -0,0 +1,138 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandHost is a host in the output of a command, port can be a number or a string
+type commandHost struct {
+	Hostname string            `json:"hostname"`
+	User     string            `json:"user"`
+	Port     interface{}       `json:"port"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// Command runs command with sh -c and timeout and returns the hosts of its output, which is a JSON list of
+// hostnames or of objects with hostname, user, port and labels. environment is added to the environment
+// of the command.
+func Command(command string, timeout time.Duration, environment map[string]string) ([]*Host, error) {
+	key := []string{"command", command}
+	for name, value := range environment {
+		key = append(key, name+"="+value)
+	}
+	sort.Strings(key[2:])
+
+	return cached(strings.Join(key, " "), func() ([]*Host, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = os.Environ()
+		for name, value := range environment {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		// children of the shell can keep stdout open after it was killed, so don't wait for them
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		if err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return nil, fmt.Errorf("%v: %s", err, message)
+			}
+			return nil, err
+		}
+		return parseCommandOutput(stdout.Bytes())
+	})
+}
+
+// parseCommandOutput parses and validates the JSON output of a command
+func parseCommandOutput(data []byte) ([]*Host, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid output, expected JSON list of hostnames or hosts: %v", err)
+	}
+
+	var hosts []*Host
+	for i, item := range items {
+		host, err := parseCommandHost(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %d: %v", i+1, err)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func parseCommandHost(item json.RawMessage) (*Host, error) {
+	var hostname string
+	if err := json.Unmarshal(item, &hostname); err == nil {
+		if hostname == "" {
+			return nil, errors.New("hostname is empty")
+		}
+		return &Host{Hostname: hostname}, nil
+	}
+
+	var h commandHost
+	decoder := json.NewDecoder(bytes.NewReader(item))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&h); err != nil {
+		return nil, err
+	} else if h.Hostname == "" {
+		return nil, errors.New("hostname must be set")
+	}
+
+	host := &Host{Hostname: h.Hostname, User: h.User, Labels: h.Labels}
+	switch port := h.Port.(type) {
+	case nil:
+	case float64:
+		host.Port = strconv.FormatFloat(port, 'f', -1, 64)
+	case string:
+		host.Port = port
+	default:
+		return nil, fmt.Errorf("port of %s must be a number or a string", h.Hostname)
+	}
+	if host.Port != "" {
+		if _, err := strconv.ParseUint(host.Port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q of %s", host.Port, h.Hostname)
+		}
+	}
+	return host, nil
+}
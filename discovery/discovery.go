@@ -0,0 +1,67 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package discovery
+
+import (
+	"sync"
+)
+
+// Host is a discovered instance, User and Port are empty, if the source doesn't define them
+type Host struct {
+	Hostname string            `json:"hostname"`
+	User     string            `json:"user,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// result is a cached discovery, done is closed, when hosts and err are set
+type result struct {
+	done  chan struct{}
+	hosts []*Host
+	err   error
+}
+
+var (
+	mutex   sync.Mutex
+	results = map[string]*result{}
+)
+
+// Reset clears the cached discoveries, it is called at the start of every run
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	results = map[string]*result{}
+}
+
+// cached returns the hosts of the discovery with key, discover is called only once per run and key,
+// concurrent callers wait for the result
+func cached(key string, discover func() ([]*Host, error)) ([]*Host, error) {
+	mutex.Lock()
+	r, ok := results[key]
+	if !ok {
+		r = &result{done: make(chan struct{})}
+		results[key] = r
+	}
+	mutex.Unlock()
+
+	if ok {
+		<-r.done
+	} else {
+		r.hosts, r.err = discover()
+		close(r.done)
+	}
+	return r.hosts, r.err
+}
@@ -0,0 +1,70 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package discovery
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lscheidler/switchctl/dns"
+)
+
+// SRV returns the targets of the SRV records of name ordered by priority, weight (highest first) and target,
+// with the port of the record
+func SRV(resolver *dns.Resolver, name string) ([]*Host, error) {
+	return cached("srv "+name+" "+resolver.String(), func() ([]*Host, error) {
+		records, err := resolver.LookupSRV(name)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(records, func(i, j int) bool {
+			if records[i].Priority != records[j].Priority {
+				return records[i].Priority < records[j].Priority
+			} else if records[i].Weight != records[j].Weight {
+				return records[i].Weight > records[j].Weight
+			}
+			return records[i].Target < records[j].Target
+		})
+
+		var hosts []*Host
+		for _, record := range records {
+			hosts = append(hosts, &Host{
+				Hostname: strings.TrimSuffix(record.Target, "."),
+				Port:     strconv.Itoa(int(record.Port)),
+			})
+		}
+		return hosts, nil
+	})
+}
+
+// Addresses returns the sorted A and AAAA records of name
+func Addresses(resolver *dns.Resolver, name string) ([]*Host, error) {
+	return cached("addresses "+name+" "+resolver.String(), func() ([]*Host, error) {
+		addresses, err := resolver.LookupHost(name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(addresses)
+
+		var hosts []*Host
+		for _, address := range addresses {
+			hosts = append(hosts, &Host{Hostname: address})
+		}
+		return hosts, nil
+	})
+}
@@ -16,13 +16,111 @@
 package dns
 
 import (
+	"context"
 	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lscheidler/switchctl/conf"
 )
 
-func Check(hostname string) bool {
-	if _, err := net.LookupHost(hostname); err != nil {
-		return false
-	} else {
-		return true
+// Resolver looks up hostnames with the DNS settings of an environment
+type Resolver struct {
+	settings    conf.DNS
+	nameservers []string
+	resolver    *net.Resolver
+	next        uint32
+}
+
+// New returns a resolver with settings, the system resolver is used, if no nameservers are set
+func New(settings *conf.DNS) *Resolver {
+	r := &Resolver{resolver: net.DefaultResolver}
+	if settings == nil {
+		return r
+	}
+	r.settings = *settings
+
+	for _, nameserver := range settings.Nameservers {
+		if _, _, err := net.SplitHostPort(nameserver); err != nil {
+			nameserver = net.JoinHostPort(nameserver, "53")
+		}
+		r.nameservers = append(r.nameservers, nameserver)
+	}
+	if len(r.nameservers) > 0 {
+		r.resolver = &net.Resolver{PreferGo: true, Dial: r.dial}
+	}
+	return r
+}
+
+// dial connects to the nameservers in turn
+func (r *Resolver) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	nameserver := r.nameservers[int(atomic.AddUint32(&r.next, 1)-1)%len(r.nameservers)]
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, nameserver)
+}
+
+// Custom returns true, if the resolver uses its own nameservers, then hostnames can only be reached by their address
+func (r *Resolver) Custom() bool {
+	return len(r.nameservers) > 0
+}
+
+// Skip returns true, if hostnames are not looked up
+func (r *Resolver) Skip() bool {
+	return r.settings.Skip
+}
+
+// String returns the settings of the resolver
+func (r *Resolver) String() string {
+	return "nameservers=" + strings.Join(r.nameservers, ",") + " search=" + strings.Join(r.settings.Search, ",")
+}
+
+// Lookup returns the name and the addresses of hostname. If hostname cannot be resolved, hostname is qualified
+// with the search domains in order. If DNS is skipped, hostname is returned without addresses.
+func (r *Resolver) Lookup(hostname string) (string, []string, error) {
+	if r.settings.Skip {
+		return hostname, nil, nil
+	}
+
+	addresses, err := r.LookupHost(hostname)
+	if err == nil || strings.HasSuffix(hostname, ".") {
+		return hostname, addresses, err
+	}
+	for _, domain := range r.settings.Search {
+		name := hostname + "." + strings.Trim(domain, ".")
+		if addresses, searchErr := r.LookupHost(name); searchErr == nil {
+			return name, addresses, nil
+		}
+	}
+	return hostname, nil, err
+}
+
+// LookupHost returns the addresses of hostname
+func (r *Resolver) LookupHost(hostname string) ([]string, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	addresses, err := r.resolver.LookupHost(ctx, hostname)
+	return addresses, r.error(err)
+}
+
+// LookupSRV returns the SRV records of name
+func (r *Resolver) LookupSRV(name string) ([]*net.SRV, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	_, records, err := r.resolver.LookupSRV(ctx, "", "", name)
+	return records, r.error(err)
+}
+
+// error replaces the server of err, which is the nameserver of resolv.conf, with the nameservers of the resolver
+func (r *Resolver) error(err error) error {
+	if dnsErr, ok := err.(*net.DNSError); ok && r.Custom() {
+		dnsErr.Server = strings.Join(r.nameservers, ",")
+	}
+	return err
+}
+
+func (r *Resolver) context() (context.Context, context.CancelFunc) {
+	if r.settings.Timeout > 0 {
+		return context.WithTimeout(context.Background(), r.settings.Timeout)
 	}
+	return context.WithCancel(context.Background())
 }
@@ -0,0 +1,170 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package freeze
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		expected []int
+		err      bool
+	}{
+		{field: "*", min: 0, max: 6, expected: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "5", min: 0, max: 59, expected: []int{5}},
+		{field: "1,3,5", min: 0, max: 59, expected: []int{1, 3, 5}},
+		{field: "9-12", min: 0, max: 23, expected: []int{9, 10, 11, 12}},
+		{field: "*/15", min: 0, max: 59, expected: []int{0, 15, 30, 45}},
+		{field: "1-10/3", min: 0, max: 59, expected: []int{1, 4, 7, 10}},
+		{field: "50/5", min: 0, max: 59, expected: []int{50, 55}},
+		{field: "0-5,30-31", min: 0, max: 59, expected: []int{0, 1, 2, 3, 4, 5, 30, 31}},
+		{field: "mon-fri", min: 0, max: 7, names: dayNames, expected: []int{1, 2, 3, 4, 5}},
+		{field: "SAT,sun", min: 0, max: 7, names: dayNames, expected: []int{0, 6}},
+		{field: "nov-dec", min: 1, max: 12, names: monthNames, expected: []int{11, 12}},
+		{field: "60", min: 0, max: 59, err: true},
+		{field: "0", min: 1, max: 31, err: true},
+		{field: "5-1", min: 0, max: 59, err: true},
+		{field: "*/0", min: 0, max: 59, err: true},
+		{field: "*/x", min: 0, max: 59, err: true},
+		{field: "x", min: 0, max: 59, err: true},
+		{field: "mon", min: 0, max: 59, err: true},
+	}
+
+	for _, test := range tests {
+		values, err := parseField(test.field, test.min, test.max, test.names)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseField(%q) = %v, expected error", test.field, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseField(%q) returned error: %v", test.field, err)
+			continue
+		}
+		var result []int
+		for value := range values {
+			result = append(result, value)
+		}
+		sort.Ints(result)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseField(%q) = %v, expected %v", test.field, result, test.expected)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 32 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("parseCron(%q) expected error", expression)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2026-10-16 is a Friday, 2026-10-17 a Saturday and 2026-10-18 a Sunday
+	date := func(day int, hour int, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expression string
+		t          time.Time
+		expected   bool
+	}{
+		{"* * * * *", date(16, 12, 34), true},
+		{"30 9 * * *", date(16, 9, 30), true},
+		{"30 9 * * *", date(16, 9, 31), false},
+		{"* 18-23 * * fri", date(16, 18, 0), true},
+		{"* 18-23 * * fri", date(16, 23, 59), true},
+		{"* 18-23 * * fri", date(16, 17, 59), false},
+		{"* 18-23 * * fri", date(17, 18, 0), false},
+		{"* * * * sat,sun", date(18, 0, 0), true},
+		{"* * * * 0", date(18, 0, 0), true},
+		{"* * * * 7", date(18, 0, 0), true},
+		{"* * * * 1-5", date(18, 0, 0), false},
+		{"*/20 * * * *", date(16, 0, 40), true},
+		{"*/20 * * * *", date(16, 0, 50), false},
+		{"* * * dec *", date(16, 0, 0), false},
+		{"* * * oct *", date(16, 0, 0), true},
+
+		// day of month or day of week must match, if both are restricted
+		{"* * 1 * fri", date(16, 0, 0), true},
+		{"* * 17 * mon", date(17, 0, 0), true},
+		{"* * 1 * mon", date(16, 0, 0), false},
+		// both must match, if one of them starts with *
+		{"* * * * fri", date(16, 0, 0), true},
+		{"* * 16 * *", date(16, 0, 0), true},
+		{"* * */2 * fri", date(16, 0, 0), false},
+		{"* * */2 * sat", date(17, 0, 0), true},
+		{"* * 16 * */2", date(16, 0, 0), false},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expression)
+		if err != nil {
+			t.Errorf("parseCron(%q) returned error: %v", test.expression, err)
+			continue
+		}
+		if result := c.matches(test.t); result != test.expected {
+			t.Errorf("%q matches %s = %v, expected %v", test.expression, test.t.Format(time.RFC3339), result, test.expected)
+		}
+	}
+}
+
+func TestCronDaylightSavingTime(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone Europe/Berlin not available: ", err)
+	}
+
+	tests := []struct {
+		name     string
+		cron     string
+		t        time.Time
+		expected bool
+	}{
+		// 2026-03-29 02:00 CET is 03:00 CEST, 02:30 local time doesn't exist
+		{"before spring forward", "* 1 * * *", time.Date(2026, time.March, 29, 0, 59, 0, 0, time.UTC), true},
+		{"skipped hour", "* 2 * * *", time.Date(2026, time.March, 29, 1, 0, 0, 0, time.UTC), false},
+		{"after spring forward", "* 3 * * *", time.Date(2026, time.March, 29, 1, 0, 0, 0, time.UTC), true},
+		// 2026-10-25 03:00 CEST is 02:00 CET, 02:30 local time occurs twice
+		{"first repeated hour", "30 2 * * *", time.Date(2026, time.October, 25, 0, 30, 0, 0, time.UTC), true},
+		{"second repeated hour", "30 2 * * *", time.Date(2026, time.October, 25, 1, 30, 0, 0, time.UTC), true},
+		{"after fall back", "30 2 * * *", time.Date(2026, time.October, 25, 2, 30, 0, 0, time.UTC), false},
+		// the day of week of the local time is used
+		{"local day of week", "* * * * sun", time.Date(2026, time.October, 24, 22, 30, 0, 0, time.UTC), true},
+	}
+
+	for _, test := range tests {
+		freeze := &conf.Freeze{Cron: test.cron, Timezone: location.String(), Reason: test.name}
+		active, _, err := isActive(freeze, test.t)
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+		} else if active != test.expected {
+			t.Errorf("%s: %q at %s (%s) = %v, expected %v", test.name, test.cron, test.t.Format(time.RFC3339), test.t.In(location).Format(time.RFC3339), active, test.expected)
+		}
+	}
+}
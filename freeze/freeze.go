@@ -0,0 +1,164 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package freeze
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Freeze is an active freeze window
+type Freeze struct {
+	Application string
+	Environment string
+	Reason      string
+	Window      string
+}
+
+func (freeze *Freeze) String() string {
+	reason := freeze.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return fmt.Sprintf("%s is frozen in %s (%s): %s", freeze.Application, freeze.Environment, freeze.Window, reason)
+}
+
+// Check returns the active freeze windows for application in environment at t
+func Check(freezes []*conf.Freeze, environment string, application string, t time.Time) ([]*Freeze, error) {
+	var result []*Freeze
+	for _, freeze := range freezes {
+		if !conf.Matches(freeze.Environments, environment) || !conf.Matches(freeze.Applications, application) {
+			continue
+		}
+
+		active, window, err := isActive(freeze, t)
+		if err != nil {
+			return nil, err
+		} else if active {
+			result = append(result, &Freeze{
+				Application: application,
+				Environment: environment,
+				Reason:      freeze.Reason,
+				Window:      window,
+			})
+		}
+	}
+	return result, nil
+}
+
+// Validate checks timezone, cron expression and dates of freeze
+func Validate(freeze *conf.Freeze) error {
+	location := time.Local
+	if freeze.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(freeze.Timezone); err != nil {
+			return err
+		}
+	}
+
+	if freeze.Cron == "" && freeze.From == "" && freeze.To == "" {
+		return fmt.Errorf("cron, from or to must be set")
+	}
+	if freeze.Cron != "" {
+		if _, err := parseCron(freeze.Cron); err != nil {
+			return err
+		}
+	}
+	if freeze.From != "" {
+		if _, _, err := parseTime(freeze.From, location); err != nil {
+			return fmt.Errorf("from: %v", err)
+		}
+	}
+	if freeze.To != "" {
+		if _, _, err := parseTime(freeze.To, location); err != nil {
+			return fmt.Errorf("to: %v", err)
+		}
+	}
+	return nil
+}
+
+func isActive(freeze *conf.Freeze, t time.Time) (bool, string, error) {
+	if freeze.Timezone != "" {
+		location, err := time.LoadLocation(freeze.Timezone)
+		if err != nil {
+			return false, "", fmt.Errorf("freeze %q: %v", freeze.Reason, err)
+		}
+		t = t.In(location)
+	}
+
+	if freeze.Cron == "" && freeze.From == "" && freeze.To == "" {
+		return false, "", fmt.Errorf("freeze %q: cron, from or to must be set", freeze.Reason)
+	}
+
+	window := []string{}
+	if freeze.From != "" {
+		from, _, err := parseTime(freeze.From, t.Location())
+		if err != nil {
+			return false, "", fmt.Errorf("freeze %q: from: %v", freeze.Reason, err)
+		}
+		if t.Before(from) {
+			return false, "", nil
+		}
+		window = append(window, "from "+freeze.From)
+	}
+	if freeze.To != "" {
+		to, dateOnly, err := parseTime(freeze.To, t.Location())
+		if err != nil {
+			return false, "", fmt.Errorf("freeze %q: to: %v", freeze.Reason, err)
+		}
+		if dateOnly {
+			// a date includes the whole day
+			to = to.AddDate(0, 0, 1)
+		}
+		if !t.Before(to) {
+			return false, "", nil
+		}
+		window = append(window, "to "+freeze.To)
+	}
+
+	// a cron expression freezes every matching minute, optionally limited by from and to
+	if freeze.Cron != "" {
+		c, err := parseCron(freeze.Cron)
+		if err != nil {
+			return false, "", fmt.Errorf("freeze %q: %v", freeze.Reason, err)
+		}
+		if !c.matches(t) {
+			return false, "", nil
+		}
+		window = append([]string{"cron " + freeze.Cron}, window...)
+	}
+	return true, strings.Join(window, " "), nil
+}
+
+func parseTime(value string, location *time.Location) (time.Time, bool, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid time %q, use e.g. 2006-01-02 or 2006-01-02T15:04", value)
+}
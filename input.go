@@ -0,0 +1,83 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+// inputBuffer is the number of lines, which are kept until they are read by a confirmation
+const inputBuffer = 16
+
+// input reads stdin in a single goroutine, which is shared by all switches of a run, e.g. of reconcile.
+// A line with 'f' toggles the output of the following switch, other lines are answers to confirmations.
+type input struct {
+	once     sync.Once
+	lines    chan string
+	mutex    sync.Mutex
+	follower *common.Follower
+}
+
+var stdin input
+
+func (in *input) start() {
+	in.once.Do(func() {
+		in.lines = make(chan string, inputBuffer)
+		go func() {
+			reader := bufio.NewReader(os.Stdin)
+			for {
+				text, err := reader.ReadString('\n')
+				if err != nil {
+					close(in.lines)
+					return
+				}
+
+				in.mutex.Lock()
+				follower := in.follower
+				in.mutex.Unlock()
+				if follower != nil && strings.TrimSpace(text) == "f" {
+					follower.Toggle()
+					continue
+				}
+
+				// lines, which nobody reads, are dropped
+				select {
+				case in.lines <- text:
+				default:
+				}
+			}
+		}()
+	})
+}
+
+// ReadLine returns the next line of stdin or an empty string at the end of stdin
+func (in *input) ReadLine() string {
+	in.start()
+	return <-in.lines
+}
+
+// Follow toggles the output of follower, when a line with 'f' is entered, until Follow is called with nil
+func (in *input) Follow(follower *common.Follower) {
+	in.start()
+	in.mutex.Lock()
+	in.follower = follower
+	in.mutex.Unlock()
+}
@@ -0,0 +1,225 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+)
+
+const (
+	defaultDirectory = "/tmp"
+	defaultTTL       = 2 * time.Hour
+
+	// exit status of the acquire script, if the lock is held by someone else
+	lockedExitStatus = 3
+)
+
+var unsafeCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Holder describes the holder of a lock, it is the content of the lock file
+type Holder struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	Host        string    `json:"host"`
+	PID         int       `json:"pid"`
+	Timestamp   time.Time `json:"timestamp"`
+	Application string    `json:"application"`
+	Environment string    `json:"environment"`
+}
+
+func (holder *Holder) String() string {
+	return fmt.Sprintf("%s@%s (pid %d) since %s", holder.User, holder.Host, holder.PID, holder.Timestamp.Local().Format(time.RFC3339))
+}
+
+// Locker acquires locks per application and environment by creating a lock file atomically on every instance
+type Locker struct {
+	slog        *zap.SugaredLogger
+	directory   string
+	ttl         time.Duration
+	environment string
+	id          string
+}
+
+func New(slog *zap.SugaredLogger, config *conf.Lock, environment string) *Locker {
+	locker := &Locker{
+		slog:        slog,
+		directory:   defaultDirectory,
+		ttl:         defaultTTL,
+		environment: environment,
+		id:          randomID(),
+	}
+	if config != nil {
+		if config.Directory != "" {
+			locker.directory = config.Directory
+		}
+		if config.TTL > 0 {
+			locker.ttl = config.TTL
+		}
+	}
+	return locker
+}
+
+// Acquire acquires the lock of application on all its instances, on failure all acquired locks are released
+func (locker *Locker) Acquire(application *common.Application) error {
+	holder := locker.holder(application)
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+
+	var acquired []*common.Instance
+	for _, instance := range application.SuccessfulInstances {
+		if err := locker.acquire(application, instance, string(data)); err != nil {
+			for _, acquiredInstance := range acquired {
+				locker.release(application, acquiredInstance)
+			}
+			return err
+		}
+		acquired = append(acquired, instance)
+	}
+	return nil
+}
+
+func (locker *Locker) acquire(application *common.Application, instance *common.Instance, data string) error {
+	filename := locker.filename(application)
+	script := fmt.Sprintf(`f=%s; if ( set -C; printf '%%s\n' %s > "$f" ) 2>/dev/null; then echo acquired; else cat "$f"; exit %d; fi`, quote(filename), quote(data), lockedExitStatus)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		command := instance.Execute(common.PhaseLock, script, "acquire lock")
+		if command.Error == nil {
+			locker.slog.Infof("%s[%s]: acquired lock %s", application.Name, instance.Hostname(), filename)
+			return nil
+		} else if command.ExitStatus() != lockedExitStatus {
+			return fmt.Errorf("%s: failed to acquire lock %s: %v (%s)", instance.Hostname(), filename, command.Error, strings.TrimSpace(command.Combined.String()))
+		}
+
+		content := strings.TrimSpace(command.Stdout.String())
+		var holder Holder
+		if err := json.Unmarshal([]byte(content), &holder); err != nil {
+			return fmt.Errorf("%s: locked by unknown holder (%s): %s", instance.Hostname(), filename, content)
+		}
+
+		if time.Since(holder.Timestamp) < locker.ttl {
+			return fmt.Errorf("%s: locked by %s", instance.Hostname(), holder.String())
+		}
+
+		// remove stale lock, if it wasn't replaced in the meantime
+		locker.slog.Warnf("%s[%s]: removing stale lock of %s", application.Name, instance.Hostname(), holder.String())
+		remove := fmt.Sprintf(`f=%s; [ "$(cat "$f")" = %s ] && rm -f "$f"; true`, quote(filename), quote(content))
+		if command := instance.Execute(common.PhaseLock, remove, "remove stale lock"); command.Error != nil {
+			return fmt.Errorf("%s: failed to remove stale lock %s: %v", instance.Hostname(), filename, command.Error)
+		}
+	}
+	return fmt.Errorf("%s: failed to acquire lock %s", instance.Hostname(), filename)
+}
+
+// Release releases the lock of application on all its instances, if it is held by this locker
+func (locker *Locker) Release(application *common.Application) {
+	for _, instance := range application.SuccessfulInstances {
+		if instance.Connected() {
+			locker.release(application, instance)
+		}
+	}
+}
+
+func (locker *Locker) release(application *common.Application, instance *common.Instance) {
+	filename := locker.filename(application)
+	script := fmt.Sprintf(`f=%s; grep -qF %s "$f" 2>/dev/null && rm -f "$f"; true`, quote(filename), quote(`"id":"`+locker.id+`"`))
+	if command := instance.Execute(common.PhaseLock, script, "release lock"); command.Error != nil {
+		locker.slog.Warnf("%s[%s]: failed to release lock %s: %v", application.Name, instance.Hostname(), filename, command.Error)
+	}
+}
+
+// Refresh renews the timestamp of the lock of application on all its instances, if it is held by this locker,
+// to keep the lock from becoming stale while waiting
+func (locker *Locker) Refresh(application *common.Application) {
+	data, err := json.Marshal(locker.holder(application))
+	if err != nil {
+		return
+	}
+
+	filename := locker.filename(application)
+	script := fmt.Sprintf(`f=%s; grep -qF %s "$f" 2>/dev/null && printf '%%s\n' %s > "$f"; true`, quote(filename), quote(`"id":"`+locker.id+`"`), quote(string(data)))
+	for _, instance := range application.SuccessfulInstances {
+		if instance.Connected() {
+			if command := instance.Execute(common.PhaseLock, script, "refresh lock"); command.Error != nil {
+				locker.slog.Warnf("%s[%s]: failed to refresh lock %s: %v", application.Name, instance.Hostname(), filename, command.Error)
+			}
+		}
+	}
+}
+
+// Unlock removes the lock of application on instance regardless of its holder and returns the removed holder
+func (locker *Locker) Unlock(application *common.Application, instance *common.Instance) (*Holder, error) {
+	filename := locker.filename(application)
+	script := fmt.Sprintf(`f=%s; if [ -e "$f" ]; then cat "$f"; rm -f "$f"; fi`, quote(filename))
+	command := instance.Execute(common.PhaseLock, script, "remove lock")
+	if command.Error != nil {
+		return nil, fmt.Errorf("%s: failed to remove lock %s: %v (%s)", instance.Hostname(), filename, command.Error, strings.TrimSpace(command.Combined.String()))
+	}
+
+	content := strings.TrimSpace(command.Stdout.String())
+	if content == "" {
+		return nil, nil
+	}
+	var holder Holder
+	if err := json.Unmarshal([]byte(content), &holder); err != nil {
+		return &Holder{User: "<unknown>"}, nil
+	}
+	return &holder, nil
+}
+
+func (locker *Locker) holder(application *common.Application) *Holder {
+	hostname, _ := os.Hostname()
+	return &Holder{
+		ID:          locker.id,
+		User:        common.CurrentUsername(),
+		Host:        hostname,
+		PID:         os.Getpid(),
+		Timestamp:   time.Now().UTC(),
+		Application: application.Name,
+		Environment: locker.environment,
+	}
+}
+
+func (locker *Locker) filename(application *common.Application) string {
+	name := unsafeCharacters.ReplaceAllString("switchctl-"+application.Name+"-"+locker.environment+".lock", "_")
+	return path.Join(locker.directory, name)
+}
+
+// quote quotes value for a posix shell
+func quote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func randomID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
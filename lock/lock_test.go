@@ -0,0 +1,288 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package lock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+)
+
+// testServer serves ssh connections, which run the commands of exec requests locally with sh -c, and an
+// ssh-agent in SSH_AUTH_SOCK to connect with. It returns the port of the ssh server.
+func testServer(t *testing.T, dir string) string {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	agentListener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { agentListener.Close() })
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: clientKey}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := agentListener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	sock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", agentListener.Addr().String())
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("SSH_AUTH_SOCK", sock)
+		} else {
+			os.Unsetenv("SSH_AUTH_SOCK")
+		}
+	})
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for request := range requests {
+				var payload struct{ Command string }
+				if request.Type != "exec" || ssh.Unmarshal(request.Payload, &payload) != nil {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 255
+					if exitError, ok := err.(*exec.ExitError); ok {
+						status = uint32(exitError.ExitCode())
+					}
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// testApplication returns an application with an instance connected to the ssh server at port
+func testApplication(t *testing.T, port string) *common.Application {
+	instance := common.NewInstance(zap.NewNop().Sugar(), "127.0.0.1", port, "test", false)
+	if err := instance.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(instance.Close)
+
+	application := common.NewApplication("app", "1.0")
+	application.SuccessfulInstances = []*common.Instance{instance}
+	return application
+}
+
+func readHolder(t *testing.T, filename string) *Holder {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var holder Holder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		t.Fatalf("invalid lock file %s: %v", data, err)
+	}
+	return &holder
+}
+
+func TestLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	application := testApplication(t, testServer(t, dir))
+	config := &conf.Lock{Directory: dir, TTL: time.Hour}
+	locker := New(zap.NewNop().Sugar(), config, "prod")
+	other := New(zap.NewNop().Sugar(), config, "prod")
+	filename := filepath.Join(dir, "switchctl-app-prod.lock")
+
+	// acquire
+	if err := locker.Acquire(application); err != nil {
+		t.Fatalf("Acquire returned %v", err)
+	}
+	holder := readHolder(t, filename)
+	if holder.ID != locker.id || holder.Application != "app" || holder.Environment != "prod" || holder.PID != os.Getpid() {
+		t.Errorf("lock file contains %+v", holder)
+	}
+	if err := other.Acquire(application); err == nil || !strings.Contains(err.Error(), "locked by "+holder.User) {
+		t.Errorf("Acquire of locked application returned %v", err)
+	}
+
+	// refresh
+	time.Sleep(10 * time.Millisecond)
+	locker.Refresh(application)
+	if refreshed := readHolder(t, filename); !refreshed.Timestamp.After(holder.Timestamp) || refreshed.ID != locker.id {
+		t.Errorf("Refresh changed lock from %+v to %+v", holder, refreshed)
+	}
+	other.Refresh(application)
+	if refreshed := readHolder(t, filename); refreshed.ID != locker.id {
+		t.Errorf("Refresh of other locker replaced lock with %+v", refreshed)
+	}
+
+	// release
+	other.Release(application)
+	if _, err := os.Stat(filename); err != nil {
+		t.Errorf("Release of other locker removed lock: %v", err)
+	}
+	locker.Release(application)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Release didn't remove lock: %v", err)
+	}
+	if err := other.Acquire(application); err != nil {
+		t.Errorf("Acquire of released lock returned %v", err)
+	}
+	other.Release(application)
+}
+
+func TestLockerStaleLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	application := testApplication(t, testServer(t, dir))
+	locker := New(zap.NewNop().Sugar(), &conf.Lock{Directory: dir, TTL: time.Hour}, "prod")
+	filename := filepath.Join(dir, "switchctl-app-prod.lock")
+
+	writeLock := func(content string) {
+		if err := ioutil.WriteFile(filename, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale, _ := json.Marshal(&Holder{ID: "stale", User: "bob", Host: "ci", PID: 1, Timestamp: time.Now().Add(-2 * time.Hour)})
+	fresh, _ := json.Marshal(&Holder{ID: "fresh", User: "bob", Host: "ci", PID: 1, Timestamp: time.Now().Add(-time.Minute)})
+
+	writeLock(string(stale))
+	if err := locker.Acquire(application); err != nil {
+		t.Fatalf("Acquire of stale lock returned %v", err)
+	}
+	if holder := readHolder(t, filename); holder.ID != locker.id {
+		t.Errorf("stale lock was replaced by %+v", holder)
+	}
+	locker.Release(application)
+
+	writeLock(string(fresh))
+	if err := locker.Acquire(application); err == nil || !strings.Contains(err.Error(), "locked by bob@ci (pid 1)") {
+		t.Errorf("Acquire of fresh lock returned %v", err)
+	}
+	if holder := readHolder(t, filename); holder.ID != "fresh" {
+		t.Errorf("fresh lock was replaced by %+v", holder)
+	}
+
+	writeLock("garbage")
+	if err := locker.Acquire(application); err == nil || !strings.Contains(err.Error(), "locked by unknown holder") {
+		t.Errorf("Acquire of invalid lock returned %v", err)
+	}
+
+	// unlock removes locks regardless of their holder
+	writeLock(string(fresh))
+	holder, err := locker.Unlock(application, application.SuccessfulInstances[0])
+	if err != nil || holder == nil || holder.ID != "fresh" {
+		t.Errorf("Unlock returned %+v, %v", holder, err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Unlock didn't remove lock: %v", err)
+	}
+	if holder, err := locker.Unlock(application, application.SuccessfulInstances[0]); err != nil || holder != nil {
+		t.Errorf("Unlock without lock returned %+v, %v", holder, err)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	locker := New(zap.NewNop().Sugar(), nil, "prod/eu")
+	if filename := locker.filename(common.NewApplication("app $(id)", "")); filename != "/tmp/switchctl-app___id_-prod_eu.lock" {
+		t.Errorf("filename = %s", filename)
+	}
+}
@@ -16,19 +16,38 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/agtorre/gocolorize"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
 	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/freeze"
+	"github.com/lscheidler/switchctl/lock"
+	"github.com/lscheidler/switchctl/metrics"
+	"github.com/lscheidler/switchctl/notify"
+	"github.com/lscheidler/switchctl/plan"
+	"github.com/lscheidler/switchctl/policy"
 	"github.com/lscheidler/switchctl/progress"
+	"github.com/lscheidler/switchctl/report"
+	"github.com/lscheidler/switchctl/run"
+	"github.com/lscheidler/switchctl/trace"
+)
+
+const (
+	metricsTimeout      = 10 * time.Second
+	notificationTimeout = 15 * time.Second
+	tracingTimeout      = 10 * time.Second
 )
 
 var (
@@ -37,35 +56,425 @@ var (
 
 func main() {
 	args := cli.ParseArguments()
-	config := conf.LoadConfig()
+	if args.Command == cli.CommandConfig && args.Arguments[0] == "validate" {
+		os.Exit(validateConfig(args))
+	} else if args.Command == cli.CommandConfig && args.Arguments[0] == "sign" {
+		os.Exit(signConfig(args))
+	}
+	config := conf.LoadConfig(args.Config)
 
 	openLog(args)
-	defer slog.Sync()
+	if config.Source != nil {
+		slog.Infof("Loaded config %s", config.Source)
+	}
+
+	if args.NoColor {
+		gocolorize.SetPlain(true)
+	}
+
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	var approved *plan.Plan
+	if args.Plan != "" {
+		var err error
+		if approved, err = loadPlan(args); err != nil {
+			fmt.Println(cred.Paint("Failed to load plan:"), err)
+			os.Exit(1)
+		}
+	}
+
+	if args.Command == cli.CommandSwitch || args.Command == cli.CommandUnlock {
+		if err := checkPolicy(args, config); err != nil {
+			slog.Warn(err)
+			fmt.Println(cred.Paint(err.Error()))
+			slog.Sync()
+			os.Exit(1)
+		}
+	}
+
+	var exitCode int
+	switch args.Command {
+	case cli.CommandApprove:
+		exitCode = approvePlan(args)
+	case cli.CommandConfig:
+		exitCode = explainConfig(args, config)
+	case cli.CommandPlan:
+		exitCode = writePlan(args)
+	case cli.CommandReconcile:
+		exitCode = reconcileState(args, config)
+	case cli.CommandServe:
+		exitCode = serve(args, config)
+	case cli.CommandUI:
+		exitCode = ui(args, config)
+	case cli.CommandUnlock:
+		exitCode = unlock(args, config)
+	default:
+		exitCode = switchApplications(args, config, approved)
+	}
+
+	slog.Sync()
+	args.Applications.Close()
+	os.Exit(exitCode)
+}
+
+func switchApplications(args *cli.Arguments, config *conf.Config, approved *plan.Plan) int {
+	var properties []report.Property
+	var notes []string
+
+	// scheduled switches are checked against the freeze windows at the scheduled time
+	at := time.Now()
+	if !args.Schedule.IsZero() {
+		at = args.Schedule
+	}
+	if justification, ok := checkFreezes(args, config, at); !ok {
+		return 1
+	} else if justification != "" {
+		properties = append(properties, report.Property{Name: "freeze_override", Value: justification})
+		notes = append(notes, "freeze override: "+justification)
+	}
+	if approvers, ok := checkApprovals(args, config, approved); !ok {
+		return 1
+	} else if approved != nil {
+		properties = append(properties, report.Property{Name: "plan", Value: approved.ID}, report.Property{Name: "approvers", Value: strings.Join(approvers, ",")})
+		notes = append(notes, "plan: "+approved.ID, "approvers: "+strings.Join(approvers, ", "))
+	}
+	r, err := run.New(args.Rundir, args.Environment, common.CurrentUsername())
+	if err != nil {
+		slog.Errorf("Failed to create run directory in %s: %v", args.Rundir, err)
+	} else {
+		properties = append(properties, report.Property{Name: "run_directory", Value: r.Directory})
+		if err := run.Prune(args.Rundir, args.KeepRuns); err != nil {
+			slog.Warnf("Failed to prune run directories in %s: %v", args.Rundir, err)
+		}
+	}
+
+	tracer := trace.NewTracer("switchctl")
+	span := tracer.StartSpan("run")
+	span.SetAttribute("user", common.CurrentUsername())
+	span.SetAttribute("environment", args.Environment)
+	span.SetAttribute("dryrun", args.Dryrun)
+	if !args.Schedule.IsZero() {
+		span.SetAttribute("schedule", args.Schedule.Format(time.RFC3339))
+		properties = append(properties, report.Property{Name: "schedule", Value: args.Schedule.Format(time.RFC3339)})
+		notes = append(notes, "scheduled: "+args.Schedule.Format(time.RFC3339))
+	}
+	if config.Source != nil {
+		span.SetAttribute("config", config.Source.String())
+		properties = append(properties, report.Property{Name: "config", Value: config.Source.Location}, report.Property{Name: "config_revision", Value: config.Source.Revision})
+		notes = append(notes, "config: "+config.Source.String())
+	}
+	properties = append(properties, report.Property{Name: "trace_id", Value: tracer.TraceID()})
 
 	cred := gocolorize.Colorize{Fg: gocolorize.Red}
 
-	defer args.Applications.Close()
-	p := progress.New(slog, args.Workers, colorizeInstanceCompleted)
+	notifier, err := notify.New(slog, config.Notifications, args.Environment, args.Dryrun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	notifier.Notify(notify.EventRunStarted, notify.Status(notify.StatusPending), args.Applications...)
+
+	view, err := progress.NewView(args.Progress, colorizeInstanceCompleted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := progress.New(slog, args.Workers, progress.MultiView(view, notifier.View()))
+	p.Trace(span)
 	p.Load(args, config)
 
 	printApplicationInformation(p)
 
+	exitCode := 0
 	if len(p.SuccessfulApplications) > 0 {
-		fmt.Println(cred.Paint("please enter 'ok' to proceed (<control>+c or <enter> for exit):"))
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
+		text := "ok\n"
+		if !args.Yes {
+			fmt.Println(cred.Paint("please enter 'ok' to proceed (<control>+c or <enter> for exit):"))
+			text = stdin.ReadLine()
+		}
 
 		if text == "ok\n" {
-			p.SwitchApplications()
+			notifier.Notify(notify.EventConfirmed, notify.Status(notify.StatusPending), p.SuccessfulApplications...)
+
+			// locks are only acquired after confirmation, a declined switch doesn't touch the instances
+			locker := lock.New(slog, config.Lock, args.Environment)
+			var locked []*common.Application
+			stopRelease := func() {}
+			if !args.Dryrun && (config.Lock == nil || !config.Lock.Disabled) {
+				failed := len(p.FailedApplications)
+				locked = lockApplications(p, locker)
+				for _, application := range p.FailedApplications[failed:] {
+					fmt.Printf("Skipping %s: %v\n", application.Name, application.Errors)
+				}
+				stopRelease = releaseOnSignal(locker, locked)
+			}
+
+			skipped := false
+			frozen := false
+			if !args.Schedule.IsZero() {
+				skipped = !waitForSchedule(args, p, locker, locked)
+				_, ok := checkFreezes(args, reloadFreezes(args, config), time.Now())
+				frozen = !ok
+			}
+
+			follower := common.NewFollower(os.Stdout, args.Follow)
+			p.Follow(follower)
+			// the fancy view would be corrupted by streamed output, so streaming can only be toggled in the plain view
+			if args.Progress == "plain" && cli.IsTerminal(os.Stdin) {
+				fmt.Println("enter 'f' and <enter> to toggle output of switch commands")
+				stdin.Follow(follower)
+			}
+
+			if frozen {
+				exitCode = 1
+			} else {
+				exitCode = p.SwitchApplications()
+			}
+			stdin.Follow(nil)
+			if skipped {
+				exitCode = 1
+			}
 			for _, application := range p.SuccessfulApplications {
 				for _, instance := range application.SuccessfulInstances {
 					slog.Debugf("%#v", instance.Commands)
 				}
 			}
+
+			stopRelease()
+			for _, application := range locked {
+				locker.Release(application)
+			}
 		}
 	} else {
 		fmt.Println("All applications failed.")
-		os.Exit(1)
+		exitCode = 1
+	}
+
+	// applications, which weren't switched, e.g. because the switch wasn't confirmed, are finished as well
+	for _, application := range args.Applications {
+		application.FinishTrace(nil)
+	}
+	span.SetAttribute("exit_code", exitCode)
+	span.Finish()
+	exportTrace(config.Tracing, tracer, r)
+
+	if r != nil {
+		if err := r.Write(args.Applications, exitCode, notes...); err != nil {
+			slog.Errorf("Failed to write run directory %s: %v", r.Directory, err)
+		}
+		fmt.Println("Transcripts written to", r.Directory)
+	}
+	writeJUnit(args, properties)
+
+	if !args.Dryrun {
+		exportMetrics(config.Metrics, args.Environment, p.SuccessfulApplications)
+	}
+
+	notifier.Notify(notify.EventRunCompleted, func(application *common.Application) string {
+		for _, failed := range p.FailedApplications {
+			if failed == application {
+				return notify.StatusSkipped
+			}
+		}
+		return notify.ApplicationStatus(application)
+	}, args.Applications...)
+	notifier.Wait(notificationTimeout)
+
+	return exitCode
+}
+
+// checkPolicy evaluates the access policy, before any ssh connection is opened
+func checkPolicy(args *cli.Arguments, config *conf.Config) error {
+	request := &policy.Request{
+		Environment: args.Environment,
+		Yes:         args.Yes,
+		Dryrun:      args.Dryrun,
+		LocksOnly:   args.Command == cli.CommandUnlock,
+	}
+	for _, application := range args.Applications {
+		request.Applications = append(request.Applications, application.Name)
+	}
+	return policy.Check(config.Access, request)
+}
+
+// checkFreezes refuses to switch, if an application is frozen at time at, unless the freeze is overridden with a
+// justification, which is returned
+func checkFreezes(args *cli.Arguments, config *conf.Config, at time.Time) (string, bool) {
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	var active []*freeze.Freeze
+	for _, application := range args.Applications {
+		freezes, err := freeze.Check(config.Freezes, args.Environment, application.Name, at)
+		if err != nil {
+			fmt.Println(cred.Paint("Failed to check freeze windows:"), err)
+			return "", false
+		}
+		active = append(active, freezes...)
+	}
+
+	if len(active) == 0 {
+		return "", true
+	}
+
+	for _, f := range active {
+		fmt.Println(cred.Paint(f.String()))
+	}
+
+	if strings.TrimSpace(args.OverrideFreeze) == "" {
+		slog.Warnf("Refusing to switch because of active freeze windows: %v", active)
+		fmt.Println("Refusing to switch, use --override-freeze \"<justification>\" to override.")
+		return "", false
+	}
+
+	slog.Warnf("%s overrides active freeze windows %v: %s", common.CurrentUsername(), active, args.OverrideFreeze)
+	fmt.Printf("Overriding freeze windows: %s\n\n", args.OverrideFreeze)
+	return args.OverrideFreeze, true
+}
+
+// lockApplications acquires the deploy locks of all successful applications,
+// applications, which cannot be locked, are skipped
+func lockApplications(p *progress.Progress, locker *lock.Locker) []*common.Application {
+	var locked []*common.Application
+	for _, application := range append([]*common.Application{}, p.SuccessfulApplications...) {
+		if err := locker.Acquire(application); err != nil {
+			slog.Warnf("%s: %v", application.Name, err)
+			p.Skip(application, err)
+		} else {
+			locked = append(locked, application)
+		}
+	}
+	return locked
+}
+
+// releaseOnSignal releases the deploy locks of applications, if switchctl is interrupted, until the returned
+// function is called
+func releaseOnSignal(locker *lock.Locker, applications []*common.Application) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Println("\nInterrupted, releasing locks...")
+		for _, application := range applications {
+			locker.Release(application)
+		}
+		slog.Sync()
+		os.Exit(130)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// exportTrace sends spans to an OTLP/HTTP endpoint or writes them to a file, if no endpoint is configured
+func exportTrace(config *conf.Tracing, tracer *trace.Tracer, r *run.Run) {
+	if config == nil {
+		config = &conf.Tracing{}
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+
+	if endpoint != "" {
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = tracingTimeout
+		}
+		if err := tracer.Export(endpoint, config.Headers, timeout); err != nil {
+			slog.Errorf("Failed to export trace to %s: %v", endpoint, err)
+		}
+		return
+	}
+
+	filename := config.File
+	if filename == "" && r != nil {
+		filename = filepath.Join(r.Directory, "trace.json")
+	}
+	if filename != "" {
+		if err := tracer.WriteFile(filename); err != nil {
+			slog.Errorf("Failed to write trace to %s: %v", filename, err)
+		}
+	}
+}
+
+func exportMetrics(config *conf.Metrics, environment string, applications []*common.Application) {
+	if config == nil || (config.Textfile == "" && config.Pushgateway == "") {
+		return
+	}
+
+	state := config.State
+	if state == "" {
+		state = config.Textfile
+	}
+	// counters pushed to a pushgateway are accumulated in the cache directory, unless state is set
+	if state == "" {
+		directory, err := os.UserCacheDir()
+		if err != nil {
+			slog.Errorf("Failed to determine cache directory for metrics state: %v", err)
+			return
+		}
+		state = filepath.Join(directory, "switchctl", "metrics.prom")
+		if err := os.MkdirAll(filepath.Dir(state), 0755); err != nil {
+			slog.Errorf("Failed to create directory for metrics state %s: %v", state, err)
+			return
+		}
+	}
+
+	m, err := metrics.Load(state)
+	if err != nil {
+		slog.Errorf("Failed to load metrics from %s: %v", state, err)
+		return
+	}
+	m.Record(environment, applications)
+
+	if state != config.Textfile {
+		if err := m.WriteTextfile(state); err != nil {
+			slog.Errorf("Failed to write metrics to %s: %v", state, err)
+		}
+	}
+	if config.Textfile != "" {
+		if err := m.WriteTextfile(config.Textfile); err != nil {
+			slog.Errorf("Failed to write metrics to %s: %v", config.Textfile, err)
+		}
+	}
+	if config.Pushgateway != "" {
+		job := config.Job
+		if job == "" {
+			job = "switchctl"
+		}
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = metricsTimeout
+		}
+		hostname, _ := os.Hostname()
+		if err := m.Push(config.Pushgateway, job, hostname, timeout); err != nil {
+			slog.Errorf("Failed to push metrics to %s: %v", config.Pushgateway, err)
+		}
+	}
+}
+
+func writeJUnit(args *cli.Arguments, properties []report.Property) {
+	if args.Junit == "" {
+		return
+	}
+
+	junit := report.NewJUnit(args.Environment)
+	for _, property := range properties {
+		junit.AddProperty(property.Name, property.Value)
+	}
+	for _, application := range args.Applications {
+		junit.AddApplication(application)
+	}
+	if err := junit.Write(args.Junit); err != nil {
+		slog.Errorf("Failed to write junit report %s: %v", args.Junit, err)
+		fmt.Println("Failed to write junit report:", err)
 	}
 }
 
@@ -118,7 +527,7 @@ func printApplicationInformation(p *progress.Progress) {
 		for _, application := range p.FailedApplications {
 			slog.Warnf("Skipping application %s because of errors (%v)", application.Name, application.Errors)
 
-			fmt.Printf("  - name:       %s\n    version:    %s\n", cred.Paint(application.Name), cred.Paint(application.Version))
+			fmt.Printf("  - name:       %s\n    version:    %s\n    errors:     %v\n", cred.Paint(application.Name), cred.Paint(application.Version), application.Errors)
 
 			for _, instance := range application.FailedInstances {
 				fmt.Printf("    - hostname: %s\n      current:  %s\n      errors:   %v\n", cred.Paint(instance.Hostname()), instance.CurrentVersion().String(), instance.Errors)
@@ -0,0 +1,241 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+const (
+	deploymentsTotal        = "switchctl_deployments_total"
+	deploymentFailuresTotal = "switchctl_deployment_failures_total"
+	instancesSwitchedTotal  = "switchctl_instances_switched_total"
+	switchDurationSeconds   = "switchctl_switch_duration_seconds"
+	lastDeployTimestamp     = "switchctl_last_deploy_timestamp_seconds"
+)
+
+var (
+	buckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+	families = []struct {
+		name string
+		typ  string
+		help string
+	}{
+		{deploymentsTotal, "counter", "Number of switched applications."},
+		{deploymentFailuresTotal, "counter", "Number of applications, which failed to switch."},
+		{instancesSwitchedTotal, "counter", "Number of successfully switched instances."},
+		{switchDurationSeconds, "histogram", "Duration of switching all instances of an application."},
+		{lastDeployTimestamp, "gauge", "Timestamp of the last successful switch of an application."},
+	}
+)
+
+// Metrics contains samples in prometheus text format, which are accumulated over all runs
+type Metrics struct {
+	samples map[string]float64
+}
+
+func New() *Metrics {
+	return &Metrics{samples: map[string]float64{}}
+}
+
+// Load loads previously written samples from filename, a missing file is ignored
+func Load(filename string) (*Metrics, error) {
+	metrics := New()
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return metrics, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("%s: invalid sample %q", filename, line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid sample %q: %v", filename, line, err)
+		}
+		metrics.samples[line[:i]] = value
+	}
+	return metrics, scanner.Err()
+}
+
+// Record adds the results of switched applications in environment
+func (metrics *Metrics) Record(environment string, applications []*common.Application) {
+	for _, application := range applications {
+		var started, finished time.Time
+		switched := 0
+		failed := false
+
+		for _, instance := range application.SuccessfulInstances {
+			command := instance.Command(common.PhaseSwitch)
+			if command == nil {
+				continue
+			}
+
+			if started.IsZero() || command.Started.Before(started) {
+				started = command.Started
+			}
+			if command.Finished.After(finished) {
+				finished = command.Finished
+			}
+			if command.Error != nil {
+				failed = true
+			} else {
+				switched++
+			}
+		}
+
+		if started.IsZero() {
+			continue
+		}
+
+		labels := fmt.Sprintf(`application="%s",environment="%s"`, escape(application.Name), escape(environment))
+		metrics.add(deploymentsTotal, labels, 1)
+		metrics.add(deploymentFailuresTotal, labels, 0)
+		metrics.add(instancesSwitchedTotal, labels, float64(switched))
+		if failed {
+			metrics.add(deploymentFailuresTotal, labels, 1)
+		} else {
+			metrics.set(lastDeployTimestamp, labels, float64(finished.Unix()))
+		}
+
+		duration := finished.Sub(started).Seconds()
+		for _, bucket := range buckets {
+			value := 0.0
+			if duration <= bucket {
+				value = 1
+			}
+			metrics.add(switchDurationSeconds+"_bucket", labels+`,le="`+strconv.FormatFloat(bucket, 'g', -1, 64)+`"`, value)
+		}
+		metrics.add(switchDurationSeconds+"_bucket", labels+`,le="+Inf"`, 1)
+		metrics.add(switchDurationSeconds+"_sum", labels, duration)
+		metrics.add(switchDurationSeconds+"_count", labels, 1)
+	}
+}
+
+// WriteTextfile writes metrics atomically in node_exporter textfile format to filename
+func (metrics *Metrics) WriteTextfile(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, metrics.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Push pushes metrics to a pushgateway compatible endpoint, grouped by job and instance
+func (metrics *Metrics) Push(endpoint string, job string, instance string, timeout time.Duration) error {
+	u := strings.TrimRight(endpoint, "/") + "/metrics/job/" + url.PathEscape(job)
+	if instance != "" {
+		u += "/instance/" + url.PathEscape(instance)
+	}
+
+	request, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(metrics.Bytes()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("unexpected status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Bytes returns metrics in prometheus text format
+func (metrics *Metrics) Bytes() []byte {
+	var buf bytes.Buffer
+
+	for _, family := range families {
+		var keys []string
+		for key := range metrics.samples {
+			name := key[:nameLength(key)]
+			if family.typ == "histogram" {
+				for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+					name = strings.TrimSuffix(name, suffix)
+				}
+			}
+			if name == family.name {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.typ)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "%s %s\n", key, strconv.FormatFloat(metrics.samples[key], 'g', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+func (metrics *Metrics) add(name string, labels string, value float64) {
+	metrics.samples[name+"{"+labels+"}"] += value
+}
+
+func (metrics *Metrics) set(name string, labels string, value float64) {
+	metrics.samples[name+"{"+labels+"}"] = value
+}
+
+func nameLength(key string) int {
+	if i := strings.Index(key, "{"); i >= 0 {
+		return i
+	}
+	return len(key)
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
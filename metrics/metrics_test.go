@@ -0,0 +1,172 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+// switched returns an application, whose instances were switched in duration, failed instances return an error
+func switched(name string, duration time.Duration, results ...bool) *common.Application {
+	application := common.NewApplication(name, "1.0")
+	started := time.Unix(1600000000, 0)
+	for _, ok := range results {
+		instance := common.NewInstance(zap.NewNop().Sugar(), name+".example.com", "22", "deploy", false)
+		command := instance.NewCommand(common.PhaseSwitch, "switch", "switch")
+		command.Started, command.Finished = started, started.Add(duration)
+		if !ok {
+			command.Error = errors.New("exit status 1")
+		}
+		application.SuccessfulInstances = append(application.SuccessfulInstances, instance)
+	}
+	return application
+}
+
+func TestRecord(t *testing.T) {
+	m := New()
+	m.Record("prod", []*common.Application{
+		switched("web", 3*time.Second, true, true),
+		switched("db", 45*time.Second, true, false),
+		common.NewApplication("skipped", "1.0"),
+	})
+
+	expected := map[string]float64{
+		`switchctl_deployments_total{application="web",environment="prod"}`:                        1,
+		`switchctl_deployment_failures_total{application="web",environment="prod"}`:                0,
+		`switchctl_instances_switched_total{application="web",environment="prod"}`:                 2,
+		`switchctl_last_deploy_timestamp_seconds{application="web",environment="prod"}`:            1600000003,
+		`switchctl_switch_duration_seconds_bucket{application="web",environment="prod",le="1"}`:    0,
+		`switchctl_switch_duration_seconds_bucket{application="web",environment="prod",le="5"}`:    1,
+		`switchctl_switch_duration_seconds_bucket{application="web",environment="prod",le="+Inf"}`: 1,
+		`switchctl_switch_duration_seconds_sum{application="web",environment="prod"}`:              3,
+		`switchctl_switch_duration_seconds_count{application="web",environment="prod"}`:            1,
+		`switchctl_deployments_total{application="db",environment="prod"}`:                         1,
+		`switchctl_deployment_failures_total{application="db",environment="prod"}`:                 1,
+		`switchctl_instances_switched_total{application="db",environment="prod"}`:                  1,
+		`switchctl_switch_duration_seconds_bucket{application="db",environment="prod",le="30"}`:    0,
+		`switchctl_switch_duration_seconds_bucket{application="db",environment="prod",le="60"}`:    1,
+	}
+	for key, value := range expected {
+		if actual, ok := m.samples[key]; !ok || actual != value {
+			t.Errorf("%s = %v, expected %v", key, actual, value)
+		}
+	}
+	if _, ok := m.samples[`switchctl_last_deploy_timestamp_seconds{application="db",environment="prod"}`]; ok {
+		t.Error("last deploy timestamp of failed application is set")
+	}
+	for key := range m.samples {
+		if strings.Contains(key, "skipped") {
+			t.Errorf("sample %s of application, which wasn't switched", key)
+		}
+	}
+}
+
+// TestPushAccumulatesCounters runs the export of metrics twice like two runs, which push to a pushgateway with
+// the samples of the previous run loaded from the state file
+func TestPushAccumulatesCounters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "metrics.prom")
+
+	var pushed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPut || request.URL.Path != "/metrics/job/switchctl/instance/ci-1" {
+			http.Error(w, "unexpected request "+request.Method+" "+request.URL.Path, http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(request.Body)
+		pushed = append(pushed, string(body))
+	}))
+	defer server.Close()
+
+	for run := 0; run < 2; run++ {
+		m, err := Load(state)
+		if err != nil {
+			t.Fatalf("run %d: Load returned %v", run, err)
+		}
+		m.Record("prod", []*common.Application{switched("web", time.Second, true, run == 0)})
+		if err := m.WriteTextfile(state); err != nil {
+			t.Fatalf("run %d: WriteTextfile returned %v", run, err)
+		}
+		if err := m.Push(server.URL+"/", "switchctl", "ci-1", time.Second); err != nil {
+			t.Fatalf("run %d: Push returned %v", run, err)
+		}
+	}
+
+	if len(pushed) != 2 {
+		t.Fatalf("pushed %d times, expected 2", len(pushed))
+	}
+	for _, line := range []string{
+		"# TYPE switchctl_deployments_total counter",
+		`switchctl_deployments_total{application="web",environment="prod"} 2`,
+		`switchctl_deployment_failures_total{application="web",environment="prod"} 1`,
+		`switchctl_instances_switched_total{application="web",environment="prod"} 3`,
+		`switchctl_switch_duration_seconds_count{application="web",environment="prod"} 2`,
+		`switchctl_switch_duration_seconds_bucket{application="web",environment="prod",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(pushed[1], line+"\n") {
+			t.Errorf("second push doesn't contain %q:\n%s", line, pushed[1])
+		}
+	}
+	if data, _ := ioutil.ReadFile(state); string(data) != pushed[1] {
+		t.Errorf("state file differs from pushed metrics:\n%s", data)
+	}
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		http.Error(w, "invalid metric", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := New().Push(server.URL, "switchctl", "", time.Second); err == nil || !strings.Contains(err.Error(), "400 Bad Request: invalid metric") {
+		t.Errorf("Push returned %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "metrics.prom")
+	if err := ioutil.WriteFile(filename, []byte("switchctl_deployments_total{} many\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filename); err == nil || !strings.Contains(err.Error(), "invalid sample") {
+		t.Errorf("Load returned %v", err)
+	}
+	if m, err := Load(filepath.Join(dir, "missing.prom")); err != nil || len(m.samples) != 0 {
+		t.Errorf("Load of missing file returned %v, %v", m, err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+const (
+	EventRunStarted           = "run_started"
+	EventConfirmed            = "confirmed"
+	EventApplicationSucceeded = "application_succeeded"
+	EventApplicationFailed    = "application_failed"
+	EventRunCompleted         = "run_completed"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSkipped   = "skipped"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Event is the payload of a notification
+type Event struct {
+	Event        string         `json:"event"`
+	Time         time.Time      `json:"time"`
+	User         string         `json:"user"`
+	Environment  string         `json:"environment"`
+	Dryrun       bool           `json:"dryrun"`
+	Text         string         `json:"text"`
+	Applications []*Application `json:"applications"`
+}
+
+type Application struct {
+	Name      string      `json:"name"`
+	Version   string      `json:"version"`
+	Status    string      `json:"status"`
+	Errors    []string    `json:"errors,omitempty"`
+	Instances []*Instance `json:"instances,omitempty"`
+}
+
+type Instance struct {
+	Hostname       string `json:"hostname"`
+	CurrentVersion string `json:"currentVersion"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+}
+
+func newApplication(application *common.Application, status string) *Application {
+	result := &Application{
+		Name:    application.Name,
+		Version: application.Version,
+		Status:  status,
+	}
+	for _, err := range application.Errors {
+		result.Errors = append(result.Errors, err.String())
+	}
+	for _, instance := range application.SuccessfulInstances {
+		result.Instances = append(result.Instances, newInstance(instance))
+	}
+	for _, instance := range application.FailedInstances {
+		result.Instances = append(result.Instances, newInstance(instance))
+	}
+	return result
+}
+
+func newInstance(instance *common.Instance) *Instance {
+	result := &Instance{
+		Hostname:       instance.Hostname(),
+		CurrentVersion: instance.CurrentVersion().String(),
+		Status:         StatusPending,
+	}
+
+	if command := instance.Command(common.PhaseSwitch); command != nil {
+		if command.Error != nil {
+			result.Status = StatusFailed
+			result.Error = command.Error.Error()
+		} else {
+			result.Status = StatusSucceeded
+		}
+	} else if len(instance.Errors) > 0 {
+		result.Status = StatusSkipped
+		result.Error = instance.Errors[len(instance.Errors)-1].String()
+	}
+	return result
+}
+
+func (event *Event) text() string {
+	prefix := ""
+	if event.Dryrun {
+		prefix = "[dryrun] "
+	}
+
+	switch event.Event {
+	case EventRunStarted:
+		return fmt.Sprintf("%s%s started switching %s in %s", prefix, event.User, event.applicationList(), event.Environment)
+	case EventConfirmed:
+		return fmt.Sprintf("%s%s confirmed switching %s in %s", prefix, event.User, event.applicationList(), event.Environment)
+	case EventApplicationSucceeded:
+		return fmt.Sprintf("%s%s switched %s in %s", prefix, event.User, event.applicationList(), event.Environment)
+	case EventApplicationFailed:
+		errors := []string{}
+		for _, application := range event.Applications {
+			errors = append(errors, application.Errors...)
+			for _, instance := range application.Instances {
+				if instance.Status == StatusFailed {
+					errors = append(errors, instance.Hostname+": "+instance.Error)
+				}
+			}
+		}
+		return fmt.Sprintf("%s%s failed to switch %s in %s: %s", prefix, event.User, event.applicationList(), event.Environment, strings.Join(errors, ", "))
+	case EventRunCompleted:
+		count := map[string]int{}
+		for _, application := range event.Applications {
+			count[application.Status]++
+		}
+		return fmt.Sprintf("%s%s finished switching in %s: %d succeeded, %d failed, %d skipped", prefix, event.User, event.Environment, count[StatusSucceeded], count[StatusFailed], count[StatusSkipped]+count[StatusPending])
+	}
+	return prefix + event.Event
+}
+
+func (event *Event) applicationList() string {
+	applications := []string{}
+	for _, application := range event.Applications {
+		applications = append(applications, application.Name+" ("+application.Version+")")
+	}
+	return strings.Join(applications, ", ")
+}
@@ -0,0 +1,283 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/progress"
+)
+
+const (
+	FormatJSON       = "json"
+	FormatSlack      = "slack"
+	FormatMattermost = "mattermost"
+
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+)
+
+var formats = map[string]string{
+	FormatSlack:      `{"text": {{ json .Text }}}`,
+	FormatMattermost: `{"username": "switchctl", "text": {{ json .Text }}}`,
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Notifier sends notifications about the lifecycle of a run to webhooks.
+// Notifications are sent in the background and never block a switch.
+type Notifier struct {
+	slog          *zap.SugaredLogger
+	notifications []*webhook
+	environment   string
+	dryrun        bool
+	user          string
+
+	wg sync.WaitGroup
+}
+
+type webhook struct {
+	*conf.Notification
+	template *template.Template
+	client   *http.Client
+}
+
+func New(slog *zap.SugaredLogger, notifications []*conf.Notification, environment string, dryrun bool) (*Notifier, error) {
+	notifier := &Notifier{
+		slog:        slog,
+		environment: environment,
+		dryrun:      dryrun,
+		user:        common.CurrentUsername(),
+	}
+
+	for _, notification := range notifications {
+		if !conf.Matches(notification.Environments, environment) {
+			continue
+		}
+
+		hook := &webhook{Notification: notification}
+		if notification.Retries != nil && *notification.Retries < 0 {
+			return nil, fmt.Errorf("notification %s: retries must not be negative", notification.URL)
+		}
+
+		text := notification.Template
+		if text == "" {
+			format := notification.Format
+			if format == "" {
+				format = FormatJSON
+			}
+			if format != FormatJSON {
+				var ok bool
+				if text, ok = formats[format]; !ok {
+					return nil, fmt.Errorf("notification %s: unknown format %s", notification.URL, format)
+				}
+			}
+		}
+		if text != "" {
+			t, err := template.New(notification.URL).Funcs(funcs).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("notification %s: %v", notification.URL, err)
+			}
+			hook.template = t
+		}
+
+		timeout := notification.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		hook.client = &http.Client{Timeout: timeout}
+
+		notifier.notifications = append(notifier.notifications, hook)
+	}
+	return notifier, nil
+}
+
+// Notify sends event with status for applications to all webhooks, which are subscribed to event
+func (notifier *Notifier) Notify(event string, status func(*common.Application) string, applications ...*common.Application) {
+	if len(notifier.notifications) == 0 {
+		return
+	}
+
+	data := &Event{
+		Event:       event,
+		Time:        time.Now(),
+		User:        notifier.user,
+		Environment: notifier.environment,
+		Dryrun:      notifier.dryrun,
+	}
+	for _, application := range applications {
+		data.Applications = append(data.Applications, newApplication(application, status(application)))
+	}
+	data.Text = data.text()
+
+	for _, hook := range notifier.notifications {
+		if !hook.hasEvent(event) {
+			continue
+		}
+
+		body, err := hook.body(data)
+		if err != nil {
+			notifier.slog.Warnf("Failed to render notification %s for %s: %v", hook.URL, event, err)
+			continue
+		}
+
+		notifier.wg.Add(1)
+		go notifier.send(hook, event, body)
+	}
+}
+
+// Wait waits at most timeout for pending notifications
+func (notifier *Notifier) Wait(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		notifier.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		notifier.slog.Warn("Giving up waiting for pending notifications")
+	}
+}
+
+// View returns a progress view, which notifies about loaded and switched applications
+func (notifier *Notifier) View() progress.View {
+	return &view{notifier: notifier}
+}
+
+func (notifier *Notifier) send(hook *webhook, event string, body []byte) {
+	defer notifier.wg.Done()
+
+	retries := defaultRetries
+	if hook.Retries != nil {
+		retries = *hook.Retries
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err = hook.post(body); err == nil {
+			notifier.slog.Debugf("Sent notification %s to %s", event, hook.URL)
+			return
+		}
+		notifier.slog.Debugf("Failed to send notification %s to %s (attempt %d): %v", event, hook.URL, attempt+1, err)
+	}
+	notifier.slog.Warnf("Failed to send notification %s to %s: %v", event, hook.URL, err)
+}
+
+func (hook *webhook) body(event *Event) ([]byte, error) {
+	if hook.template == nil {
+		return json.Marshal(event)
+	}
+
+	var buf bytes.Buffer
+	if err := hook.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (hook *webhook) post(body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := hook.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (hook *webhook) hasEvent(event string) bool {
+	return conf.Matches(hook.Events, event)
+}
+
+// view sends application events
+type view struct {
+	progress.NoneView
+	notifier *Notifier
+}
+
+func (view *view) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+	if err != nil {
+		view.notifier.Notify(EventApplicationFailed, Status(StatusSkipped), application)
+	}
+}
+
+func (view *view) ApplicationFinished(application *common.Application, failed bool) {
+	if failed {
+		view.notifier.Notify(EventApplicationFailed, Status(StatusFailed), application)
+	} else {
+		view.notifier.Notify(EventApplicationSucceeded, Status(StatusSucceeded), application)
+	}
+}
+
+// Status returns a status function, which returns status for every application
+func Status(status string) func(*common.Application) string {
+	return func(*common.Application) string {
+		return status
+	}
+}
+
+// ApplicationStatus returns the status of application derived from its instances
+func ApplicationStatus(application *common.Application) string {
+	switched := false
+	for _, instance := range application.SuccessfulInstances {
+		if command := instance.Command(common.PhaseSwitch); command != nil {
+			if command.Error != nil {
+				return StatusFailed
+			}
+			switched = true
+		}
+	}
+
+	if switched {
+		return StatusSucceeded
+	}
+	return StatusPending
+}
@@ -0,0 +1,141 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agtorre/gocolorize"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/plan"
+	"github.com/lscheidler/switchctl/ssh"
+)
+
+// writePlan writes the applications to switch in an environment to a plan file, which can be approved
+func writePlan(args *cli.Arguments) int {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	p := plan.New(args.Environment, common.CurrentUsername(), args.ExpiresIn)
+	for _, application := range args.Applications {
+		p.AddApplication(application.Name, application.Version)
+	}
+
+	filename := args.Arguments[0]
+	if err := p.Write(filename); err != nil {
+		slog.Errorf("Failed to write plan %s: %v", filename, err)
+		fmt.Println(cred.Paint("Failed to write plan:"), err)
+		return 1
+	}
+	slog.Infof("%s created plan %s for %s, which expires at %s", p.CreatedBy, p.ID, p.Environment, p.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Plan %s written to %s, expires at %s\n", cyellow.Paint(p.ID), filename, p.ExpiresAt.Local().Format("2006-01-02 15:04:05 MST"))
+	return 0
+}
+
+// approvePlan signs a plan file with a key of ssh-agent(1)
+func approvePlan(args *cli.Arguments) int {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	filename := args.Arguments[0]
+	p, err := plan.Load(filename)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to load plan:"), err)
+		return 1
+	}
+
+	printPlan(p)
+
+	approval, err := p.Approve(common.CurrentUsername(), args.Key)
+	if err != nil {
+		slog.Errorf("Failed to approve plan %s: %v", p.ID, err)
+		fmt.Println(cred.Paint("Failed to approve plan:"), err)
+		return 1
+	}
+	if err := p.Write(filename); err != nil {
+		slog.Errorf("Failed to write plan %s: %v", filename, err)
+		fmt.Println(cred.Paint("Failed to write plan:"), err)
+		return 1
+	}
+	slog.Infof("%s approved plan %s", approval.Name, p.ID)
+	fmt.Printf("Plan %s approved by %s\n", cyellow.Paint(p.ID), cyellow.Paint(approval.Name))
+	return 0
+}
+
+// loadPlan replaces environment and applications of args with the ones of the plan file
+func loadPlan(args *cli.Arguments) (*plan.Plan, error) {
+	p, err := plan.Load(args.Plan)
+	if err != nil {
+		return nil, err
+	}
+
+	args.Environment = p.Environment
+	for _, application := range p.Applications {
+		args.Applications = append(args.Applications, common.NewApplication(application.Name, application.Version))
+	}
+	return p, nil
+}
+
+// checkApprovals refuses to switch, if the environment and applications require an approved plan,
+// which is missing or not sufficiently approved. It returns the names of the approvers.
+func checkApprovals(args *cli.Arguments, config *conf.Config, approved *plan.Plan) ([]string, bool) {
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	if approved == nil {
+		var names []string
+		for _, application := range args.Applications {
+			names = append(names, application.Name)
+		}
+		if !args.Dryrun && plan.RequiresApproval(config.Approvals, args.Environment, names) {
+			slog.Warnf("Refusing to switch %v in %s without approved plan", names, args.Environment)
+			fmt.Println(cred.Paint("Refusing to switch without approved plan, use the plan and approve commands and switch with --plan <plan.json>."))
+			return nil, false
+		}
+		return nil, true
+	}
+
+	// keys of the local ssh-agent belong to the executor, their approvals don't count
+	keys, _ := ssh.AgentKeys()
+	approvers, err := approved.Verify(config.Approvals, common.CurrentUsername(), keys)
+	if err != nil {
+		slog.Warnf("Refusing to switch plan %s: %v", approved.ID, err)
+		fmt.Println(cred.Paint("Refusing to switch plan:"), err)
+		return nil, false
+	}
+	slog.Infof("%s switches plan %s of %s approved by %v", common.CurrentUsername(), approved.ID, approved.CreatedBy, approvers)
+	if len(approvers) > 0 {
+		fmt.Printf("Plan %s approved by %s\n\n", approved.ID, strings.Join(approvers, ", "))
+	}
+	return approvers, true
+}
+
+func printPlan(p *plan.Plan) {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+
+	fmt.Printf("Plan %s for %s created by %s at %s, expires at %s:\n\n", cyellow.Paint(p.ID), cyellow.Paint(p.Environment), p.CreatedBy, p.CreatedAt.Format("2006-01-02 15:04:05 MST"), p.ExpiresAt.Local().Format("2006-01-02 15:04:05 MST"))
+	for _, application := range p.Applications {
+		fmt.Printf("  - name:       %s\n    version:    %s\n", cyellow.Paint(application.Name), cyellow.Paint(application.Version))
+	}
+	for _, approval := range p.Approvals {
+		fmt.Printf("\n  approved by %s at %s\n", approval.Name, approval.Timestamp.Format("2006-01-02 15:04:05 MST"))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,258 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package plan
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/ssh"
+)
+
+// Namespace is the SSHSIG namespace of plan approvals, it differs from the namespace of config signatures
+const Namespace = "switchctl-plan"
+
+// DefaultValidity is the time, after which plans expire, unless another validity is set
+const DefaultValidity = 24 * time.Hour
+
+// Plan describes the applications and versions to switch in an environment, it can be approved by signing it
+type Plan struct {
+	ID           string         `json:"id"`
+	Environment  string         `json:"environment"`
+	Applications []*Application `json:"applications"`
+	CreatedBy    string         `json:"createdBy"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	ExpiresAt    time.Time      `json:"expiresAt"`
+
+	Approvals []*Approval `json:"approvals,omitempty"`
+}
+
+type Application struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Approval is an SSHSIG signature of the plan payload with an ssh key
+type Approval struct {
+	Name      string    `json:"name"`
+	PublicKey string    `json:"publicKey"`
+	Signature string    `json:"signature"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// New returns a plan of createdBy for environment, which expires after validity
+func New(environment string, createdBy string, validity time.Duration) *Plan {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	createdAt := time.Now().UTC()
+	return &Plan{
+		ID:          hex.EncodeToString(id),
+		Environment: environment,
+		CreatedBy:   createdBy,
+		CreatedAt:   createdAt,
+		ExpiresAt:   createdAt.Add(validity),
+	}
+}
+
+// Expired returns true, if the plan expired or has no expiry
+func (plan *Plan) Expired() bool {
+	return !time.Now().Before(plan.ExpiresAt)
+}
+
+func (plan *Plan) AddApplication(name string, version string) {
+	plan.Applications = append(plan.Applications, &Application{Name: name, Version: version})
+}
+
+func Load(filename string) (*Plan, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &plan, nil
+}
+
+func (plan *Plan) Write(filename string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Payload returns the signed part of the plan, which excludes the approvals
+func (plan *Plan) Payload() ([]byte, error) {
+	payload := *plan
+	payload.Approvals = nil
+	return json.Marshal(&payload)
+}
+
+// Approve signs the plan with key of ssh-agent(1) and adds the approval of name
+func (plan *Plan) Approve(name string, key string) (*Approval, error) {
+	if plan.Expired() {
+		return nil, plan.expiredError()
+	}
+	payload, err := plan.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, signature, err := ssh.AgentSign(payload, key, Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	approval := &Approval{
+		Name:      name,
+		PublicKey: publicKey,
+		Signature: string(signature),
+		Timestamp: time.Now().UTC(),
+	}
+	plan.Approvals = append(plan.Approvals, approval)
+	return approval, nil
+}
+
+// Verify checks, that the plan isn't expired and every approval rule, which matches the environment and an
+// application of the plan, is fulfilled by valid signatures of distinct approvers. Approvals of the executor
+// or the creator of the plan don't count: neither their approver names, nor the keys of approvers with their
+// names, nor keys, which are known to belong to the executor (e.g. keys of its ssh-agent), are accepted.
+// It returns the names of all valid approvers.
+func (plan *Plan) Verify(rules []*conf.Approval, executor string, executorKeys []string) ([]string, error) {
+	if plan.Expired() {
+		return nil, plan.expiredError()
+	}
+	payload, err := plan.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[string]bool{}
+	for _, key := range executorKeys {
+		excluded[normalizeKey(key)] = true
+	}
+	for _, rule := range rules {
+		for _, approver := range rule.Approvers {
+			if approver.Name == executor || approver.Name == plan.CreatedBy {
+				excluded[normalizeKey(approver.Key)] = true
+			}
+		}
+	}
+
+	valid := map[string]bool{}
+	for _, rule := range rules {
+		if !plan.matches(rule) {
+			continue
+		}
+
+		required := rule.Required
+		if required <= 0 {
+			required = 1
+		}
+
+		approvers := map[string]bool{}
+		names := []string{}
+		for _, approver := range rule.Approvers {
+			names = append(names, approver.Name)
+			if approver.Name == executor || approver.Name == plan.CreatedBy || excluded[normalizeKey(approver.Key)] {
+				continue
+			}
+			for _, approval := range plan.Approvals {
+				if approval.verify(approver, payload) {
+					approvers[approver.Name] = true
+					valid[approver.Name] = true
+				}
+			}
+		}
+
+		if len(approvers) < required {
+			others := executor
+			if plan.CreatedBy != executor {
+				others += " and " + plan.CreatedBy
+			}
+			return nil, fmt.Errorf("%s requires %d approval(s) of [%s] different from %s, found %d valid approval(s)", plan.Environment, required, strings.Join(names, ", "), others, len(approvers))
+		}
+	}
+
+	var result []string
+	for name := range valid {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (plan *Plan) expiredError() error {
+	if plan.ExpiresAt.IsZero() {
+		return fmt.Errorf("plan %s has no expiry, create a new plan", plan.ID)
+	}
+	return fmt.Errorf("plan %s expired at %s, create a new plan", plan.ID, plan.ExpiresAt.Local().Format(time.RFC3339))
+}
+
+// RequiresApproval returns true, if an approval rule matches environment and one of applications
+func RequiresApproval(rules []*conf.Approval, environment string, applications []string) bool {
+	plan := &Plan{Environment: environment}
+	for _, application := range applications {
+		plan.AddApplication(application, "")
+	}
+	for _, rule := range rules {
+		if plan.matches(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+func (plan *Plan) matches(rule *conf.Approval) bool {
+	if !conf.Matches(rule.Environments, plan.Environment) {
+		return false
+	}
+	for _, application := range plan.Applications {
+		if conf.Matches(rule.Applications, application.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (approval *Approval) verify(approver *conf.Approver, payload []byte) bool {
+	return ssh.Verify(approver.Key, payload, Namespace, []byte(approval.Signature)) == nil
+}
+
+// normalizeKey returns the wire format of a public key in authorized_keys format, which ignores options and comment
+func normalizeKey(key string) string {
+	publicKey, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return key
+	}
+	return string(publicKey.Marshal())
+}
@@ -0,0 +1,218 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package plan
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+// testAgent serves an ssh-agent with a new ed25519 key per name in SSH_AUTH_SOCK and returns the public keys
+func testAgent(t *testing.T, names ...string) map[string]string {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := agent.NewKeyring()
+	keys := map[string]string{}
+	for _, name := range names {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: private, Comment: name}); err != nil {
+			t.Fatal(err)
+		}
+		publicKey, err := ssh.NewPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))) + " " + name
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+	t.Cleanup(func() {
+		os.Setenv("SSH_AUTH_SOCK", sock)
+		listener.Close()
+		os.RemoveAll(dir)
+	})
+	return keys
+}
+
+func newPlan() *Plan {
+	plan := New("prod", "carol", time.Hour)
+	plan.AddApplication("app", "1.0")
+	return plan
+}
+
+func approve(t *testing.T, plan *Plan, names ...string) {
+	for _, name := range names {
+		if _, err := plan.Approve(name, name); err != nil {
+			t.Fatalf("Approve of %s returned %v", name, err)
+		}
+	}
+}
+
+func rules(keys map[string]string, required int) []*conf.Approval {
+	rule := &conf.Approval{Environments: []string{"prod"}, Required: required}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		rule.Approvers = append(rule.Approvers, &conf.Approver{Name: name, Key: keys[name]})
+	}
+	return []*conf.Approval{rule}
+}
+
+func TestVerify(t *testing.T) {
+	keys := testAgent(t, "alice", "bob", "carol", "dave")
+
+	plan := newPlan()
+	approve(t, plan, "alice", "bob")
+	approvers, err := plan.Verify(rules(keys, 2), "dave", nil)
+	if err != nil {
+		t.Fatalf("Verify returned %v", err)
+	}
+	if strings.Join(approvers, ",") != "alice,bob" {
+		t.Errorf("Verify returned approvers %v, expected [alice bob]", approvers)
+	}
+
+	// the payload is signed, approvals can't be moved to another plan
+	plan.Applications[0].Version = "2.0"
+	if _, err := plan.Verify(rules(keys, 1), "dave", nil); err == nil || !strings.Contains(err.Error(), "found 0 valid approval(s)") {
+		t.Errorf("Verify of tampered plan returned %v, expected no valid approvals", err)
+	}
+}
+
+func TestVerifyRequiresDistinctApprovers(t *testing.T) {
+	keys := testAgent(t, "alice", "bob", "carol", "dave")
+
+	// the same key approving twice and an approval with the name of another approver count once
+	plan := newPlan()
+	approve(t, plan, "alice", "alice")
+	plan.Approvals[1].Name = "bob"
+	if _, err := plan.Verify(rules(keys, 2), "dave", nil); err == nil || !strings.Contains(err.Error(), "requires 2 approval(s)") || !strings.Contains(err.Error(), "found 1 valid approval(s)") {
+		t.Errorf("Verify returned %v, expected 1 of 2 approvals", err)
+	}
+
+	approve(t, plan, "bob")
+	if _, err := plan.Verify(rules(keys, 2), "dave", nil); err != nil {
+		t.Errorf("Verify returned %v", err)
+	}
+}
+
+func TestVerifyExcludesExecutorAndCreator(t *testing.T) {
+	keys := testAgent(t, "alice", "bob", "carol", "dave")
+
+	tests := []struct {
+		name         string
+		approvers    []string
+		executor     string
+		executorKeys []string
+		err          string
+	}{
+		{"executor", []string{"alice", "dave"}, "dave", nil, "different from dave and carol, found 1 valid approval(s)"},
+		{"creator", []string{"alice", "carol"}, "dave", nil, "different from dave and carol, found 1 valid approval(s)"},
+		{"key of executor agent", []string{"alice", "bob"}, "mallory", []string{keys["bob"]}, "found 1 valid approval(s)"},
+		{"configured key of creator", []string{"alice", "carol"}, "carol", nil, "different from carol, found 1 valid approval(s)"},
+		{"others", []string{"alice", "bob"}, "dave", []string{keys["dave"]}, ""},
+	}
+	for _, test := range tests {
+		plan := newPlan()
+		approve(t, plan, test.approvers...)
+		_, err := plan.Verify(rules(keys, 2), test.executor, test.executorKeys)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: returned %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestVerifyExcludesKeyOfCreatorWithAnotherName(t *testing.T) {
+	keys := testAgent(t, "alice", "bob", "carol", "dave")
+
+	// bob is configured with the key of carol, who created the plan
+	r := rules(keys, 2)
+	r[0].Approvers[1].Key = keys["carol"]
+	plan := newPlan()
+	approve(t, plan, "alice", "carol")
+	plan.Approvals[1].Name = "bob"
+	if _, err := plan.Verify(r, "dave", nil); err == nil || !strings.Contains(err.Error(), "found 1 valid approval(s)") {
+		t.Errorf("Verify returned %v, expected the key of the creator to be rejected", err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	keys := testAgent(t, "alice", "bob")
+
+	plan := newPlan()
+	approve(t, plan, "alice", "bob")
+	plan.ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := plan.Verify(rules(keys, 1), "dave", nil); err == nil || !strings.Contains(err.Error(), "expired at") {
+		t.Errorf("Verify of expired plan returned %v", err)
+	}
+	if _, err := plan.Approve("alice", "alice"); err == nil || !strings.Contains(err.Error(), "expired at") {
+		t.Errorf("Approve of expired plan returned %v", err)
+	}
+
+	plan.ExpiresAt = time.Time{}
+	if _, err := plan.Verify(rules(keys, 1), "dave", nil); err == nil || !strings.Contains(err.Error(), "has no expiry") {
+		t.Errorf("Verify of plan without expiry returned %v", err)
+	}
+}
+
+func TestVerifyUnmatchedRules(t *testing.T) {
+	plan := newPlan()
+	r := []*conf.Approval{{Environments: []string{"staging"}, Required: 1}, {Applications: []string{"db"}, Required: 1}}
+	approvers, err := plan.Verify(r, "dave", nil)
+	if err != nil || len(approvers) != 0 {
+		t.Errorf("Verify returned %v, %v, expected no approvers", approvers, err)
+	}
+	if RequiresApproval(r, "prod", []string{"app"}) {
+		t.Error("RequiresApproval returned true for unmatched rules")
+	}
+	if !RequiresApproval(r, "prod", []string{"app", "db"}) {
+		t.Error("RequiresApproval returned false for matching rule")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package policy
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+const (
+	ConfirmationAny         = "any"
+	ConfirmationInteractive = "interactive"
+	ConfirmationYes         = "yes"
+)
+
+// Request describes what a user is going to do
+type Request struct {
+	// User is the authenticated identity of the requester, e.g. the token or session of an api client.
+	// The local user and its groups are used, if User is empty.
+	User string
+	// Groups are the groups of User, e.g. the groups of the local user of a web ui session (see LookupGroups).
+	// Groups of access rules never match api tokens, which have no groups.
+	Groups []string
+
+	Environment  string
+	Applications []string
+	Yes          bool
+	Dryrun       bool
+
+	// LocksOnly is set for commands, which only manage locks, only users and groups are checked
+	LocksOnly bool
+}
+
+// Denial is returned, if the access policy denies a request
+type Denial struct {
+	User        string
+	Application string
+	Environment string
+	Reason      string
+}
+
+func (denial *Denial) Error() string {
+	return fmt.Sprintf("access denied for %s to %s in %s: %s", denial.User, denial.Application, denial.Environment, denial.Reason)
+}
+
+// Check evaluates all rules, which match environment and application of request.
+// Every matching rule must allow the request.
+func Check(rules []*conf.AccessRule, request *Request) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	username := request.User
+	groups := request.Groups
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("cannot determine local user: %v", err)
+		}
+		username = current.Username
+		groups = groupNames(current)
+	}
+
+	for _, application := range request.Applications {
+		for _, rule := range rules {
+			if !conf.Matches(rule.Environments, request.Environment) || !conf.Matches(rule.Applications, application) {
+				continue
+			}
+
+			if reason := check(rule, request, username, groups); reason != "" {
+				return &Denial{
+					User:        username,
+					Application: application,
+					Environment: request.Environment,
+					Reason:      reason,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func check(rule *conf.AccessRule, request *Request, username string, groups []string) string {
+	if len(rule.Users) > 0 || len(rule.Groups) > 0 {
+		allowed := false
+		for _, u := range rule.Users {
+			if u == username {
+				allowed = true
+			}
+		}
+		for _, group := range groups {
+			for _, g := range rule.Groups {
+				if g == group {
+					allowed = true
+				}
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("only users [%s] and groups [%s] are allowed", strings.Join(rule.Users, ", "), strings.Join(rule.Groups, ", "))
+		}
+	}
+
+	if request.LocksOnly {
+		return ""
+	}
+
+	switch rule.Confirmation {
+	case "", ConfirmationAny:
+	case ConfirmationInteractive:
+		if request.Yes {
+			return "interactive confirmation is required, --yes is not allowed"
+		}
+	case ConfirmationYes:
+		if !request.Yes {
+			return "non-interactive switches with --yes are required"
+		}
+	default:
+		return fmt.Sprintf("unknown confirmation %q in access policy", rule.Confirmation)
+	}
+
+	if rule.DryrunOnly && !request.Dryrun {
+		return "only dryruns are allowed, use --dryrun"
+	}
+	return ""
+}
+
+// LookupGroups returns the names of the groups of the local user username
+func LookupGroups(username string) ([]string, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	return groupNames(u), nil
+}
+
+func groupNames(u *user.User) []string {
+	var names []string
+	ids, err := u.GroupIds()
+	if err != nil {
+		return names
+	}
+	for _, id := range ids {
+		if group, err := user.LookupGroupId(id); err == nil {
+			names = append(names, group.Name)
+		}
+	}
+	return names
+}
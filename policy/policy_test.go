@@ -0,0 +1,106 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package policy
+
+import (
+	"os/user"
+	"strings"
+	"testing"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+func TestCheck(t *testing.T) {
+	rules := []*conf.AccessRule{
+		{Environments: []string{"prod"}, Users: []string{"alice"}, Groups: []string{"ops"}},
+		{Environments: []string{"prod"}, Applications: []string{"db"}, Confirmation: ConfirmationInteractive},
+		{Environments: []string{"prod"}, Applications: []string{"ci-web"}, Confirmation: ConfirmationYes},
+		{Environments: []string{"qa"}, DryrunOnly: true},
+		{Environments: []string{"locks"}, Users: []string{"alice"}, DryrunOnly: true},
+	}
+
+	tests := []struct {
+		name    string
+		request *Request
+		err     string
+	}{
+		{"allowed user", &Request{User: "alice", Environment: "prod", Applications: []string{"web"}}, ""},
+		{"denied user", &Request{User: "bob", Environment: "prod", Applications: []string{"web"}}, "access denied for bob to web in prod: only users [alice] and groups [ops] are allowed"},
+		{"allowed group", &Request{User: "bob", Groups: []string{"dev", "ops"}, Environment: "prod", Applications: []string{"web"}}, ""},
+		{"denied groups", &Request{User: "bob", Groups: []string{"dev"}, Environment: "prod", Applications: []string{"web"}}, "only users [alice] and groups [ops] are allowed"},
+		{"unmatched environment", &Request{User: "bob", Environment: "staging", Applications: []string{"web"}}, ""},
+		{"every application", &Request{User: "alice", Environment: "prod", Applications: []string{"web", "db"}, Yes: true}, "access denied for alice to db in prod: interactive confirmation is required"},
+		{"interactive", &Request{User: "alice", Environment: "prod", Applications: []string{"db"}}, ""},
+		{"yes required", &Request{User: "alice", Environment: "prod", Applications: []string{"ci-web"}}, "non-interactive switches with --yes are required"},
+		{"yes", &Request{User: "alice", Environment: "prod", Applications: []string{"ci-web"}, Yes: true}, ""},
+		{"dryrun only", &Request{User: "bob", Environment: "qa", Applications: []string{"web"}}, "only dryruns are allowed"},
+		{"dryrun", &Request{User: "bob", Environment: "qa", Applications: []string{"web"}, Dryrun: true}, ""},
+		{"locks only", &Request{User: "alice", Environment: "locks", Applications: []string{"web"}, LocksOnly: true}, ""},
+		{"locks only denied user", &Request{User: "bob", Environment: "locks", Applications: []string{"web"}, LocksOnly: true}, "only users [alice] and groups [] are allowed"},
+	}
+	for _, test := range tests {
+		err := Check(rules, test.request)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: returned %v, expected %q", test.name, err, test.err)
+		} else if _, ok := err.(*Denial); !ok {
+			t.Errorf("%s: returned %T, expected *Denial", test.name, err)
+		}
+	}
+}
+
+func TestCheckUnknownConfirmation(t *testing.T) {
+	rules := []*conf.AccessRule{{Confirmation: "sometimes"}}
+	if err := Check(rules, &Request{User: "alice", Environment: "prod", Applications: []string{"web"}}); err == nil || !strings.Contains(err.Error(), `unknown confirmation "sometimes"`) {
+		t.Errorf("Check returned %v, expected unknown confirmation", err)
+	}
+}
+
+func TestCheckWithoutRules(t *testing.T) {
+	if err := Check(nil, &Request{User: "bob", Environment: "prod", Applications: []string{"web"}}); err != nil {
+		t.Errorf("Check without rules returned %v", err)
+	}
+}
+
+func TestCheckLocalUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	rules := []*conf.AccessRule{{Users: []string{current.Username}}}
+	if err := Check(rules, &Request{Environment: "prod", Applications: []string{"web"}}); err != nil {
+		t.Errorf("Check of local user %s returned %v", current.Username, err)
+	}
+	// the identity of api clients is never replaced by the local user
+	if err := Check(rules, &Request{User: current.Username + "-token", Environment: "prod", Applications: []string{"web"}}); err == nil {
+		t.Errorf("Check of api client succeeded for rule of local user %s", current.Username)
+	}
+
+	groups, err := LookupGroups(current.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) > 0 {
+		rules = []*conf.AccessRule{{Groups: groups[:1]}}
+		if err := Check(rules, &Request{Environment: "prod", Applications: []string{"web"}}); err != nil {
+			t.Errorf("Check of local user %s in group %s returned %v", current.Username, groups[0], err)
+		}
+	}
+}
@@ -16,156 +16,90 @@
 package progress
 
 import (
-	"os"
 	"sync"
 	"time"
 
 	"github.com/vbauerster/mpb"
 	"github.com/vbauerster/mpb/decor"
-	"go.uber.org/zap"
 
-	"github.com/lscheidler/switchctl/cli"
 	"github.com/lscheidler/switchctl/common"
-	"github.com/lscheidler/switchctl/conf"
 )
 
-type Progress struct {
-	slog                      *zap.SugaredLogger
-	FailedApplications        []*common.Application
-	SuccessfulApplications    []*common.Application
+// mpbView displays spinners for interactive terminals
+type mpbView struct {
 	colorizeInstanceCompleted func(string, bool) string
-	workers                   int
+
+	p       *mpb.Progress
+	loadBar *mpb.Bar
+	bars    map[*common.Application]*mpb.Bar
+	failed  map[*common.Application]*bool
+	doneWg  sync.WaitGroup
 }
 
-func New(slog *zap.SugaredLogger, workers int, colorizeInstanceCompleted func(string, bool) string) *Progress {
-	return &Progress{
-		slog:                      slog,
+func NewMpbView(colorizeInstanceCompleted func(string, bool) string) View {
+	return &mpbView{
 		colorizeInstanceCompleted: colorizeInstanceCompleted,
-		workers:                   workers,
 	}
 }
 
-func (progress *Progress) Load(args *cli.Arguments, config *conf.Config) {
-	var wg sync.WaitGroup
-	var successMutex sync.Mutex
-	var failMutex sync.Mutex
-
-	p := mpb.New(
-		mpb.WithWaitGroup(&wg),
+func (view *mpbView) LoadStarted(applications []*common.Application) {
+	view.p = mpb.New(
 		mpb.WithWidth(1),
 	)
 
-	wg.Add(len(args.Applications))
-
-	var bar *mpb.Bar
-	bar = p.AddSpinner(int64(len(args.Applications)), mpb.SpinnerOnMiddle,
+	view.loadBar = view.p.AddSpinner(int64(len(applications)), mpb.SpinnerOnMiddle,
 		mpb.PrependDecorators(
 			decor.Name("Loading version information", decor.WCSyncSpaceR),
 		),
 		mpb.BarRemoveOnComplete(),
 	)
-
-	wp := NewWorkerPool(progress.workers)
-	for _, application := range []*common.Application(args.Applications) {
-		progress.slog.Debug("Loading application ", application.Name)
-
-		//go progress.loadApplication(&wg, bar, application, config, args, &successMutex, &failMutex)
-		go progress.loadApplication(wp, &wg, bar, application, config, args, &successMutex, &failMutex)
-	}
-	p.Wait()
-	wp.Close()
 }
 
-func (progress *Progress) loadApplication(wp *WorkerPool, wg *sync.WaitGroup, bar *mpb.Bar, application *common.Application, config *conf.Config, args *cli.Arguments, successMutex *sync.Mutex, failMutex *sync.Mutex) {
-	defer wg.Done()
-	defer wp.Done()
-	wp.Add()
-
-	start := time.Now()
-	if err := application.Load(progress.slog, config, args.Environment, args.Dryrun); err != nil {
-		failMutex.Lock()
-		progress.FailedApplications = append(progress.FailedApplications, application)
-		failMutex.Unlock()
-	} else {
-		successMutex.Lock()
-		progress.SuccessfulApplications = append(progress.SuccessfulApplications, application)
-		successMutex.Unlock()
-	}
-	bar.IncrBy(1, time.Since(start))
-	progress.slog.Debug("Loaded application ", application.Name)
+func (view *mpbView) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+	view.loadBar.IncrBy(1, duration)
 }
 
-func (progress *Progress) SwitchApplications() {
-	var doneWg sync.WaitGroup
-	p := mpb.New(mpb.WithWidth(1), mpb.WithWaitGroup(&doneWg))
-	wp := NewWorkerPool(progress.workers)
-
-	failed := []*bool{}
+func (view *mpbView) LoadFinished() {
+	view.p.Wait()
+}
 
-	var bars []*mpb.Bar
-	var switchWgg []*sync.WaitGroup
-	for _, application := range progress.SuccessfulApplications {
-		progress.slog.Info("Switching application ", application.Name, "=", application.Version)
+func (view *mpbView) SwitchStarted(applications []*common.Application) {
+	view.p = mpb.New(mpb.WithWidth(1), mpb.WithWaitGroup(&view.doneWg))
+	view.bars = map[*common.Application]*mpb.Bar{}
+	view.failed = map[*common.Application]*bool{}
 
-		var wg sync.WaitGroup
+	for _, application := range applications {
 		applicationFailed := false
-		failed = append(failed, &applicationFailed)
-
-		instanceChan := make(chan int)
-		wg.Add(1)
-		switchWgg = append(switchWgg, &wg)
+		view.failed[application] = &applicationFailed
 
-		var b *mpb.Bar
-		b = p.AddSpinner(
+		view.bars[application] = view.p.AddSpinner(
 			int64(len(application.SuccessfulInstances)),
 			mpb.SpinnerOnMiddle,
 			mpb.BarClearOnComplete(),
 			mpb.PrependDecorators(
 				decor.Name(application.Name, decor.WCSyncSpaceR),
-				OnStepFunction(application.InstanceCompleted(progress.colorizeInstanceCompleted), decor.WCSyncSpaceR),
+				OnStepFunction(application.InstanceCompleted(view.colorizeInstanceCompleted), decor.WCSyncSpaceR),
 			),
 			mpb.AppendDecorators(
 				decor.OnComplete(OnCompleteFailed(&applicationFailed, "finished with errors"), "done!"),
 			),
 		)
-		bars = append(bars, b)
-
-		go progress.switchApplication(&applicationFailed, wp, &wg, &instanceChan, b, application)
 	}
-	exitCode := 0
-	for i, _ := range progress.SuccessfulApplications {
-		switchWgg[i].Wait()
+}
 
-		if *failed[i] {
-			exitCode = 1
-		}
+func (view *mpbView) InstanceStarted(application *common.Application, instance *common.Instance) {
+}
+
+func (view *mpbView) InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration) {
+	if command.Error != nil {
+		*view.failed[application] = true
 	}
-	p.Wait()
-	wp.Close()
+	view.bars[application].IncrBy(1, duration)
+}
 
-	os.Exit(exitCode)
+func (view *mpbView) ApplicationFinished(application *common.Application, failed bool) {
 }
 
-func (progress *Progress) switchApplication(failed *bool, wp *WorkerPool, wg *sync.WaitGroup, instanceChan *chan int, bar *mpb.Bar, application *common.Application) {
-	defer wg.Done()
-	defer wp.Done()
-	wp.Add()
-
-	start := time.Now()
-	for _, instance := range application.SuccessfulInstances {
-
-		if instance.Connected() && len(instance.Errors) == 0 {
-			if command := instance.Switch(application.Name, application.Version); command.Error != nil {
-				progress.slog.Warnf("%s[%s] failed: %s, %s", application.Name, instance.Hostname(), command.Description, command.Error)
-				progress.slog.Warnf("%s[%s] output: %s", application.Name, instance.Hostname(), command.Combined)
-				bar.IncrBy(1, time.Since(start))
-				*failed = true
-				return
-			} else {
-				progress.slog.Debugf("%s[%s] output: %s", application.Name, instance.Hostname(), command.Combined)
-			}
-			bar.IncrBy(1, time.Since(start))
-		}
-	}
-	progress.slog.Debug("Switched application ", application.Name)
+func (view *mpbView) SwitchFinished() {
+	view.p.Wait()
 }
@@ -0,0 +1,88 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+// plainView writes line-oriented, timestamped events, e.g. for ci logs
+type plainView struct {
+	out   io.Writer
+	mutex sync.Mutex
+}
+
+func NewPlainView(out io.Writer) View {
+	return &plainView{out: out}
+}
+
+func (view *plainView) printf(format string, a ...interface{}) {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+
+	fmt.Fprintf(view.out, time.Now().Format(time.RFC3339)+" "+format+"\n", a...)
+}
+
+func (view *plainView) LoadStarted(applications []*common.Application) {
+	view.printf("loading version information for %d application(s)", len(applications))
+}
+
+func (view *plainView) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+	if err != nil {
+		view.printf("%s: loading failed in %s: %v", application.Name, round(duration), err)
+	} else {
+		view.printf("%s: loaded %d instance(s) in %s", application.Name, len(application.SuccessfulInstances), round(duration))
+	}
+}
+
+func (view *plainView) LoadFinished() {
+}
+
+func (view *plainView) SwitchStarted(applications []*common.Application) {
+	view.printf("switching %d application(s)", len(applications))
+}
+
+func (view *plainView) InstanceStarted(application *common.Application, instance *common.Instance) {
+	view.printf("%s %s: switch started", application.Name, instance.Hostname())
+}
+
+func (view *plainView) InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration) {
+	if command.Error != nil {
+		view.printf("%s %s: switch failed in %s: %v", application.Name, instance.Hostname(), round(duration), command.Error)
+	} else {
+		view.printf("%s %s: switch succeeded in %s", application.Name, instance.Hostname(), round(duration))
+	}
+}
+
+func (view *plainView) ApplicationFinished(application *common.Application, failed bool) {
+	if failed {
+		view.printf("%s: finished with errors", application.Name)
+	} else {
+		view.printf("%s: done", application.Name)
+	}
+}
+
+func (view *plainView) SwitchFinished() {
+}
+
+func round(duration time.Duration) time.Duration {
+	return duration.Round(100 * time.Millisecond)
+}
@@ -0,0 +1,211 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package progress
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/discovery"
+	"github.com/lscheidler/switchctl/trace"
+)
+
+type Progress struct {
+	slog                   *zap.SugaredLogger
+	FailedApplications     []*common.Application
+	SuccessfulApplications []*common.Application
+	view                   View
+	follower               *common.Follower
+	span                   *trace.Span
+	workers                int
+}
+
+func New(slog *zap.SugaredLogger, workers int, view View) *Progress {
+	return &Progress{
+		slog:    slog,
+		view:    view,
+		workers: workers,
+	}
+}
+
+// Follow streams the output of switch commands to follower
+func (progress *Progress) Follow(follower *common.Follower) {
+	progress.follower = follower
+}
+
+// Trace records spans of loading and switching applications as children of span
+func (progress *Progress) Trace(span *trace.Span) {
+	progress.span = span
+}
+
+func (progress *Progress) Load(args *cli.Arguments, config *conf.Config) {
+	span := progress.span.StartSpan("load")
+	defer span.Finish()
+
+	// hosts are discovered once per run
+	discovery.Reset()
+
+	var wg sync.WaitGroup
+	var successMutex sync.Mutex
+	var failMutex sync.Mutex
+
+	wg.Add(len(args.Applications))
+	progress.view.LoadStarted(args.Applications)
+
+	wp := NewWorkerPool(progress.workers)
+	for _, application := range []*common.Application(args.Applications) {
+		progress.slog.Debug("Loading application ", application.Name)
+		application.Trace(progress.span.StartSpan("application"))
+
+		go progress.loadApplication(wp, &wg, application, config, args, &successMutex, &failMutex)
+	}
+	wg.Wait()
+	progress.view.LoadFinished()
+	wp.Close()
+}
+
+func (progress *Progress) loadApplication(wp *WorkerPool, wg *sync.WaitGroup, application *common.Application, config *conf.Config, args *cli.Arguments, successMutex *sync.Mutex, failMutex *sync.Mutex) {
+	defer wg.Done()
+	defer wp.Done()
+	wp.Add()
+
+	start := time.Now()
+	err := application.Load(progress.slog, config, args.Environment, args.Dryrun)
+	if err != nil {
+		application.FinishTrace(err)
+		failMutex.Lock()
+		progress.FailedApplications = append(progress.FailedApplications, application)
+		failMutex.Unlock()
+	} else {
+		successMutex.Lock()
+		progress.SuccessfulApplications = append(progress.SuccessfulApplications, application)
+		successMutex.Unlock()
+	}
+	progress.view.ApplicationLoaded(application, time.Since(start), err)
+	progress.slog.Debug("Loaded application ", application.Name)
+}
+
+// Skip moves application to failed applications because of err
+func (progress *Progress) Skip(application *common.Application, err error) {
+	for i, successful := range progress.SuccessfulApplications {
+		if successful == application {
+			progress.SuccessfulApplications = append(progress.SuccessfulApplications[:i], progress.SuccessfulApplications[i+1:]...)
+			break
+		}
+	}
+	application.Errors = append(application.Errors, &common.Error{Message: err.Error()})
+	application.FinishTrace(err)
+	progress.FailedApplications = append(progress.FailedApplications, application)
+}
+
+// CheckDrift re-reads the versions of all successful applications and skips applications,
+// whose version changed on an instance since they were loaded
+func (progress *Progress) CheckDrift() {
+	for _, application := range append([]*common.Application{}, progress.SuccessfulApplications...) {
+		for _, instance := range application.SuccessfulInstances {
+			loaded := instance.CurrentVersion().String()
+			if command := instance.GetVersion(application.Name); command.Error != nil {
+				progress.Skip(application, fmt.Errorf("%s: failed to re-check version: %v", instance.Hostname(), command.Error))
+				break
+			} else if current := instance.CurrentVersion().String(); current != loaded {
+				progress.slog.Warnf("%s[%s]: version drifted from %s to %s", application.Name, instance.Hostname(), loaded, current)
+				progress.Skip(application, fmt.Errorf("%s: version drifted from %s to %s", instance.Hostname(), loaded, current))
+				break
+			}
+		}
+	}
+}
+
+// Prefetch re-runs prefetch of all successful applications and skips applications, which failed
+func (progress *Progress) Prefetch(environment string) {
+	for _, application := range append([]*common.Application{}, progress.SuccessfulApplications...) {
+		if err := application.Prefetch(progress.slog, environment); err != nil {
+			progress.Skip(application, err)
+		}
+	}
+}
+
+func (progress *Progress) SwitchApplications() int {
+	span := progress.span.StartSpan("switch")
+	defer span.Finish()
+
+	var wg sync.WaitGroup
+	wp := NewWorkerPool(progress.workers)
+
+	progress.view.SwitchStarted(progress.SuccessfulApplications)
+
+	failed := make([]bool, len(progress.SuccessfulApplications))
+	for i, application := range progress.SuccessfulApplications {
+		progress.slog.Info("Switching application ", application.Name, "=", application.Version)
+
+		wg.Add(1)
+		go progress.switchApplication(&failed[i], wp, &wg, application)
+	}
+	wg.Wait()
+	progress.view.SwitchFinished()
+	wp.Close()
+
+	exitCode := 0
+	for _, applicationFailed := range failed {
+		if applicationFailed {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+func (progress *Progress) switchApplication(failed *bool, wp *WorkerPool, wg *sync.WaitGroup, application *common.Application) {
+	defer wg.Done()
+	defer wp.Done()
+	wp.Add()
+
+	for _, instance := range application.SuccessfulInstances {
+		if instance.Connected() && len(instance.Errors) == 0 {
+			if progress.follower != nil {
+				instance.Follow(progress.follower, application.Name+" "+instance.Hostname()+": ")
+			}
+			progress.view.InstanceStarted(application, instance)
+
+			start := time.Now()
+			command := instance.Switch(application.Name, application.Version)
+			instance.FinishTrace(command.Error)
+			progress.view.InstanceFinished(application, instance, command, time.Since(start))
+
+			if command.Error != nil {
+				progress.slog.Warnf("%s[%s] failed: %s, %s", application.Name, instance.Hostname(), command.Description, command.Error)
+				progress.slog.Warnf("%s[%s] output: %s", application.Name, instance.Hostname(), command.Combined)
+				*failed = true
+				break
+			} else {
+				progress.slog.Debugf("%s[%s] output: %s", application.Name, instance.Hostname(), command.Combined)
+			}
+		}
+	}
+	progress.view.ApplicationFinished(application, *failed)
+	if *failed {
+		application.FinishTrace(errors.New("finished with errors"))
+	} else {
+		application.FinishTrace(nil)
+	}
+	progress.slog.Debug("Switched application ", application.Name)
+}
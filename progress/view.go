@@ -0,0 +1,127 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package progress
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+const (
+	ModeFancy = "fancy"
+	ModePlain = "plain"
+	ModeNone  = "none"
+)
+
+// View displays the progress of loading and switching applications
+type View interface {
+	LoadStarted(applications []*common.Application)
+	ApplicationLoaded(application *common.Application, duration time.Duration, err error)
+	LoadFinished()
+
+	SwitchStarted(applications []*common.Application)
+	InstanceStarted(application *common.Application, instance *common.Instance)
+	InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration)
+	ApplicationFinished(application *common.Application, failed bool)
+	SwitchFinished()
+}
+
+// NewView returns the view for mode
+func NewView(mode string, colorizeInstanceCompleted func(string, bool) string) (View, error) {
+	switch mode {
+	case ModeFancy:
+		return NewMpbView(colorizeInstanceCompleted), nil
+	case ModePlain:
+		return NewPlainView(os.Stdout), nil
+	case ModeNone:
+		return &NoneView{}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+}
+
+// NoneView doesn't display anything, it can be embedded by views, which are only interested in some events
+type NoneView struct{}
+
+func (view *NoneView) LoadStarted(applications []*common.Application) {}
+func (view *NoneView) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+}
+func (view *NoneView) LoadFinished()                                                              {}
+func (view *NoneView) SwitchStarted(applications []*common.Application)                           {}
+func (view *NoneView) InstanceStarted(application *common.Application, instance *common.Instance) {}
+func (view *NoneView) InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration) {
+}
+func (view *NoneView) ApplicationFinished(application *common.Application, failed bool) {}
+func (view *NoneView) SwitchFinished()                                                  {}
+
+// multiView passes all events to its views
+type multiView []View
+
+// MultiView returns a view, which passes all events to views
+func MultiView(views ...View) View {
+	return multiView(views)
+}
+
+func (views multiView) LoadStarted(applications []*common.Application) {
+	for _, view := range views {
+		view.LoadStarted(applications)
+	}
+}
+
+func (views multiView) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+	for _, view := range views {
+		view.ApplicationLoaded(application, duration, err)
+	}
+}
+
+func (views multiView) LoadFinished() {
+	for _, view := range views {
+		view.LoadFinished()
+	}
+}
+
+func (views multiView) SwitchStarted(applications []*common.Application) {
+	for _, view := range views {
+		view.SwitchStarted(applications)
+	}
+}
+
+func (views multiView) InstanceStarted(application *common.Application, instance *common.Instance) {
+	for _, view := range views {
+		view.InstanceStarted(application, instance)
+	}
+}
+
+func (views multiView) InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration) {
+	for _, view := range views {
+		view.InstanceFinished(application, instance, command, duration)
+	}
+}
+
+func (views multiView) ApplicationFinished(application *common.Application, failed bool) {
+	for _, view := range views {
+		view.ApplicationFinished(application, failed)
+	}
+}
+
+func (views multiView) SwitchFinished() {
+	for _, view := range views {
+		view.SwitchFinished()
+	}
+}
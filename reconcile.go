@@ -0,0 +1,213 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/agtorre/gocolorize"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/plan"
+	"github.com/lscheidler/switchctl/reconcile"
+	"github.com/lscheidler/switchctl/ssh"
+)
+
+const (
+	// exitDifferences is the exit code of reconcile, if differences were not applied
+	exitDifferences = 2
+)
+
+// reconcileState compares the versions of the desired state file with the current versions and applies differences
+// according to the reconcile mode of each environment, with the watch option repeatedly
+func reconcileState(args *cli.Arguments, config *conf.Config) int {
+	for {
+		exitCode := reconcileOnce(args, config)
+		if !args.Watch {
+			return exitCode
+		}
+		fmt.Printf("Next reconcile at %s\n\n", time.Now().Add(args.Interval).Format("2006-01-02 15:04:05 MST"))
+		time.Sleep(args.Interval)
+	}
+}
+
+func reconcileOnce(args *cli.Arguments, config *conf.Config) int {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	desired, err := reconcile.LoadDesired(args.Arguments[0])
+	if err != nil {
+		slog.Errorf("Failed to load desired state: %v", err)
+		fmt.Println(cred.Paint("Failed to load desired state:"), err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, environment := range desired.EnvironmentNames() {
+		mode, err := reconcile.Mode(config.Reconcile, environment)
+		if err != nil {
+			fmt.Println(cred.Paint(environment+":"), err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Printf("%s (%s):\n", cyellow.Paint(environment), mode)
+		// compare connects to the instances, so the access policy is checked first for all desired applications
+		var desiredApplications common.Applications
+		for _, name := range desired.ApplicationNames(environment) {
+			desiredApplications = append(desiredApplications, common.NewApplication(name, desired.Environments[environment][name]))
+		}
+		if err := checkPolicy(reconcileArguments(args, environment, mode, desiredApplications), config); err != nil {
+			slog.Warn(err)
+			fmt.Println(" ", cred.Paint(err.Error()))
+			fmt.Println()
+			exitCode = 1
+			continue
+		}
+		differences := reconcile.Compare(slog, config, environment, desired.Environments[environment])
+		if len(differences) == 0 {
+			fmt.Println("  in sync")
+			continue
+		}
+
+		var applications common.Applications
+		for _, difference := range differences {
+			slog.Warnf("%s: %s", environment, difference.String())
+			fmt.Println("  -", difference.String())
+			if difference.Error != "" {
+				exitCode = 1
+			} else if difference.Drift() && !args.CorrectDrift {
+				exitCode = maxExitCode(exitCode, exitDifferences)
+			} else {
+				applications = append(applications, common.NewApplication(difference.Application, difference.Desired))
+			}
+		}
+		fmt.Println()
+
+		if len(applications) > 0 {
+			exitCode = maxExitCode(exitCode, applyDifferences(args, config, environment, mode, applications))
+		}
+	}
+	return exitCode
+}
+
+// applyDifferences switches applications to their desired versions according to mode
+func applyDifferences(args *cli.Arguments, config *conf.Config, environment string, mode string, applications common.Applications) int {
+	var approved *plan.Plan
+	switch mode {
+	case reconcile.ModeReport:
+		fmt.Println("Differences are not applied in report mode.")
+		return exitDifferences
+	case reconcile.ModeConfirm:
+		if args.Watch {
+			fmt.Println("Differences require confirmation, run reconcile without --watch to apply them.")
+			return exitDifferences
+		}
+	case reconcile.ModeApproval:
+		var ok bool
+		if approved, ok = reconcilePlan(args, config, environment, applications); !ok {
+			return exitDifferences
+		}
+	}
+
+	a := reconcileArguments(args, environment, mode, applications)
+	defer a.Applications.Close()
+
+	exitCode := switchApplications(a, config, approved)
+	if approved != nil && exitCode == 0 {
+		os.Remove(reconcilePlanFilename(args, environment))
+	}
+	return exitCode
+}
+
+// reconcileArguments returns the arguments of switching applications in environment in mode, the access policy
+// is checked with them before versions are compared
+func reconcileArguments(args *cli.Arguments, environment string, mode string, applications common.Applications) *cli.Arguments {
+	a := *args
+	a.Command = cli.CommandSwitch
+	a.Environment = environment
+	a.Applications = applications
+	a.Yes = mode != reconcile.ModeConfirm
+	// differences are never applied in report mode
+	a.Dryrun = args.Dryrun || mode == reconcile.ModeReport
+	return &a
+}
+
+// reconcilePlan writes a plan for applications in environment, unless a plan of the same applications exists,
+// and returns the plan, if it is approved. No plan is needed, if no approval rule matches the applications.
+func reconcilePlan(args *cli.Arguments, config *conf.Config, environment string, applications common.Applications) (*plan.Plan, bool) {
+	var names []string
+	for _, application := range applications {
+		names = append(names, application.Name)
+	}
+	if !plan.RequiresApproval(config.Approvals, environment, names) {
+		return nil, true
+	}
+
+	filename := reconcilePlanFilename(args, environment)
+
+	p, err := plan.Load(filename)
+	if err != nil || p.Expired() || !samePlan(p, environment, applications) {
+		p = plan.New(environment, common.CurrentUsername(), args.ExpiresIn)
+		for _, application := range applications {
+			p.AddApplication(application.Name, application.Version)
+		}
+		if err := p.Write(filename); err != nil {
+			slog.Errorf("Failed to write plan %s: %v", filename, err)
+			fmt.Println("Failed to write plan:", err)
+			return nil, false
+		}
+		slog.Infof("Wrote plan %s for %s to %s", p.ID, environment, filename)
+	}
+
+	keys, _ := ssh.AgentKeys()
+	if _, err := p.Verify(config.Approvals, common.CurrentUsername(), keys); err == nil {
+		return p, true
+	}
+	fmt.Printf("Differences require approval, approve with: switchctl approve %s\n", filename)
+	return nil, false
+}
+
+func reconcilePlanFilename(args *cli.Arguments, environment string) string {
+	return filepath.Join(filepath.Dir(args.Logfile), "reconcile-"+environment+".json")
+}
+
+// samePlan returns true, if p switches the same applications and versions in environment
+func samePlan(p *plan.Plan, environment string, applications common.Applications) bool {
+	if p.Environment != environment || len(p.Applications) != len(applications) {
+		return false
+	}
+	for i, application := range applications {
+		if p.Applications[i].Name != application.Name || p.Applications[i].Version != application.Version {
+			return false
+		}
+	}
+	return true
+}
+
+func maxExitCode(a int, b int) int {
+	if a == 1 || b == 1 {
+		return 1
+	} else if a > b {
+		return a
+	}
+	return b
+}
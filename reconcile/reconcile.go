@@ -0,0 +1,189 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package reconcile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+)
+
+const (
+	// ModeAuto switches differences without confirmation
+	ModeAuto = "auto"
+	// ModeConfirm switches differences after interactive confirmation
+	ModeConfirm = "confirm"
+	// ModeApproval writes a plan of the differences, which must be approved
+	ModeApproval = "approval"
+	// ModeReport only reports differences
+	ModeReport = "report"
+)
+
+// Desired is the desired state with the versions of applications per environment
+type Desired struct {
+	Environments map[string]map[string]string `yaml:"environments"`
+}
+
+// Difference is an application, whose current versions differ from the desired version
+type Difference struct {
+	Application string
+	Environment string
+	Desired     string
+	Instances   []*Instance
+	Error       string
+}
+
+// Instance is the current version of an application on an instance
+type Instance struct {
+	Hostname string
+	Current  string
+}
+
+func LoadDesired(filename string) (*Desired, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var desired Desired
+	if err := yaml.UnmarshalStrict(data, &desired); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for environment, applications := range desired.Environments {
+		for application, version := range applications {
+			if version == "" {
+				return nil, fmt.Errorf("%s: version of %s in %s must be set", filename, application, environment)
+			}
+		}
+	}
+	return &desired, nil
+}
+
+// EnvironmentNames returns the sorted environments of the desired state
+func (desired *Desired) EnvironmentNames() []string {
+	var names []string
+	for name := range desired.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplicationNames returns the sorted applications of environment in the desired state
+func (desired *Desired) ApplicationNames(environment string) []string {
+	var names []string
+	for name := range desired.Environments[environment] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mode returns the mode of the first rule, which matches environment, or report
+func Mode(rules []*conf.Reconcile, environment string) (string, error) {
+	for _, rule := range rules {
+		if !conf.Matches(rule.Environments, environment) {
+			continue
+		}
+		switch rule.Mode {
+		case ModeAuto, ModeConfirm, ModeApproval, ModeReport:
+			return rule.Mode, nil
+		case "":
+			return ModeReport, nil
+		default:
+			return "", fmt.Errorf("unknown reconcile mode %q", rule.Mode)
+		}
+	}
+	return ModeReport, nil
+}
+
+// Compare compares the desired versions of applications in environment with the current versions of their instances
+// and returns the applications, which differ
+func Compare(slog *zap.SugaredLogger, config *conf.Config, environment string, applications map[string]string) []*Difference {
+	var names []string
+	for name := range applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var differences []*Difference
+	for _, name := range names {
+		application := common.NewApplication(name, applications[name])
+		err := application.GetInstances(slog, config, environment, true)
+
+		difference := &Difference{Application: name, Environment: environment, Desired: application.Version}
+		if err != nil {
+			difference.Error = err.Error()
+		}
+		for _, instance := range application.SuccessfulInstances {
+			current := ""
+			if version := instance.CurrentVersion(); version != nil {
+				current = version.CurrentVersion
+			}
+			difference.Instances = append(difference.Instances, &Instance{Hostname: instance.Hostname(), Current: current})
+		}
+		application.Close()
+
+		if difference.Error != "" || len(difference.Outdated()) > 0 {
+			differences = append(differences, difference)
+		}
+	}
+	return differences
+}
+
+// Outdated returns the instances, which don't run the desired version
+func (difference *Difference) Outdated() []*Instance {
+	var outdated []*Instance
+	for _, instance := range difference.Instances {
+		if instance.Current != difference.Desired {
+			outdated = append(outdated, instance)
+		}
+	}
+	return outdated
+}
+
+// Drift returns true, if only some instances don't run the desired version
+func (difference *Difference) Drift() bool {
+	outdated := len(difference.Outdated())
+	return outdated > 0 && outdated < len(difference.Instances)
+}
+
+func (difference *Difference) String() string {
+	if difference.Error != "" {
+		return fmt.Sprintf("%s: %s", difference.Application, difference.Error)
+	}
+
+	var instances []string
+	for _, instance := range difference.Outdated() {
+		current := instance.Current
+		if current == "" {
+			current = "<not_found>"
+		}
+		instances = append(instances, instance.Hostname+"="+current)
+	}
+	kind := "outdated"
+	if difference.Drift() {
+		kind = "drift"
+	}
+	return fmt.Sprintf("%s: %s, desired %s, current %s", difference.Application, kind, difference.Desired, strings.Join(instances, ", "))
+}
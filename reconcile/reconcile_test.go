@@ -0,0 +1,175 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package reconcile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/conf"
+)
+
+func TestMode(t *testing.T) {
+	rules := []*conf.Reconcile{
+		{Environments: []string{"staging"}, Mode: ModeAuto},
+		{Environments: []string{"qa"}},
+		{Environments: []string{"dev"}, Mode: "sometimes"},
+		{Environments: []string{"prod", "staging"}, Mode: ModeApproval},
+	}
+
+	tests := []struct {
+		environment string
+		mode        string
+		err         string
+	}{
+		{"staging", ModeAuto, ""},
+		{"prod", ModeApproval, ""},
+		{"qa", ModeReport, ""},
+		{"other", ModeReport, ""},
+		{"dev", "", `unknown reconcile mode "sometimes"`},
+	}
+	for _, test := range tests {
+		mode, err := Mode(rules, test.environment)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Mode(%s) returned %v, expected %q", test.environment, err, test.err)
+			}
+		} else if err != nil || mode != test.mode {
+			t.Errorf("Mode(%s) returned %q, %v, expected %q", test.environment, mode, err, test.mode)
+		}
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		name       string
+		difference *Difference
+		outdated   []string
+		drift      bool
+		text       string
+	}{
+		{
+			name: "in sync",
+			difference: &Difference{Application: "app", Desired: "1.0", Instances: []*Instance{
+				{Hostname: "app-1", Current: "1.0"},
+				{Hostname: "app-2", Current: "1.0"},
+			}},
+		},
+		{
+			name: "outdated",
+			difference: &Difference{Application: "app", Desired: "1.1", Instances: []*Instance{
+				{Hostname: "app-1", Current: "1.0"},
+				{Hostname: "app-2"},
+			}},
+			outdated: []string{"app-1", "app-2"},
+			text:     "app: outdated, desired 1.1, current app-1=1.0, app-2=<not_found>",
+		},
+		{
+			name: "drift",
+			difference: &Difference{Application: "app", Desired: "1.1", Instances: []*Instance{
+				{Hostname: "app-1", Current: "1.1"},
+				{Hostname: "app-2", Current: "1.0"},
+			}},
+			outdated: []string{"app-2"},
+			drift:    true,
+			text:     "app: drift, desired 1.1, current app-2=1.0",
+		},
+		{
+			name:       "error",
+			difference: &Difference{Application: "app", Desired: "1.1", Error: "no instances found"},
+			text:       "app: no instances found",
+		},
+	}
+	for _, test := range tests {
+		var outdated []string
+		for _, instance := range test.difference.Outdated() {
+			outdated = append(outdated, instance.Hostname)
+		}
+		if !reflect.DeepEqual(outdated, test.outdated) {
+			t.Errorf("%s: Outdated returned %v, expected %v", test.name, outdated, test.outdated)
+		}
+		if drift := test.difference.Drift(); drift != test.drift {
+			t.Errorf("%s: Drift returned %v, expected %v", test.name, drift, test.drift)
+		}
+		if text := test.difference.String(); test.text != "" && text != test.text {
+			t.Errorf("%s: String returned %q, expected %q", test.name, text, test.text)
+		}
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	config := &conf.Config{}
+	differences := Compare(zap.NewNop().Sugar(), config, "prod", map[string]string{"web": "1.0", "db": "2.0"})
+
+	if len(differences) != 2 {
+		t.Fatalf("Compare returned %d differences, expected 2", len(differences))
+	}
+	for i, name := range []string{"db", "web"} {
+		difference := differences[i]
+		if difference.Application != name || difference.Environment != "prod" || difference.Error == "" {
+			t.Errorf("Compare returned %+v, expected an error of %s in prod", difference, name)
+		}
+	}
+}
+
+func TestLoadDesired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"environments:\n  prod:\n    web: 1.0\n    db: 2.0\n  staging:\n    web: 1.1\n", ""},
+		{"environments:\n  prod:\n    web: \"\"\n", "version of web in prod must be set"},
+		{"environment:\n  prod:\n    web: 1.0\n", "field environment not found"},
+	}
+	for i, test := range tests {
+		filename := filepath.Join(dir, "desired.yml")
+		if err := ioutil.WriteFile(filename, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		desired, err := LoadDesired(filename)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%d: LoadDesired returned %v, expected %q", i, err, test.err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d: LoadDesired returned %v", i, err)
+			continue
+		}
+
+		if names := desired.EnvironmentNames(); !reflect.DeepEqual(names, []string{"prod", "staging"}) {
+			t.Errorf("EnvironmentNames returned %v", names)
+		}
+		if names := desired.ApplicationNames("prod"); !reflect.DeepEqual(names, []string{"db", "web"}) {
+			t.Errorf("ApplicationNames returned %v", names)
+		}
+		if version := desired.Environments["prod"]["web"]; version != "1.0" {
+			t.Errorf("desired version of web in prod is %q, expected 1.0", version)
+		}
+	}
+}
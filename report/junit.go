@@ -0,0 +1,186 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package report
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+var phases = []string{
+	common.PhaseConnect,
+	common.PhaseVersion,
+	common.PhasePrefetch,
+	common.PhaseSwitch,
+}
+
+type JUnit struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Name    string       `xml:"name,attr"`
+	Tests   int          `xml:"tests,attr"`
+	Failed  int          `xml:"failures,attr"`
+	Skipped int          `xml:"skipped,attr"`
+	Time    float64      `xml:"time,attr"`
+	Suites  []*TestSuite `xml:"testsuite"`
+
+	environment string
+	properties  []Property
+}
+
+type TestSuite struct {
+	Name       string      `xml:"name,attr"`
+	Tests      int         `xml:"tests,attr"`
+	Failed     int         `xml:"failures,attr"`
+	Skipped    int         `xml:"skipped,attr"`
+	Time       float64     `xml:"time,attr"`
+	Properties []Property  `xml:"properties>property,omitempty"`
+	Cases      []*TestCase `xml:"testcase"`
+}
+
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type TestCase struct {
+	Name      string   `xml:"name,attr"`
+	Classname string   `xml:"classname,attr"`
+	Time      float64  `xml:"time,attr"`
+	Failure   *Failure `xml:"failure,omitempty"`
+	Skipped   *Skipped `xml:"skipped,omitempty"`
+	SystemOut string   `xml:"system-out,omitempty"`
+}
+
+type Failure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type Skipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func NewJUnit(environment string) *JUnit {
+	return &JUnit{
+		Name:        "switchctl",
+		environment: environment,
+	}
+}
+
+// AddProperty adds a property, which is attached to every test suite
+func (junit *JUnit) AddProperty(name string, value string) {
+	junit.properties = append(junit.properties, Property{Name: name, Value: value})
+}
+
+// AddApplication adds a test suite for application with one test case per instance and phase
+func (junit *JUnit) AddApplication(application *common.Application) {
+	suite := &TestSuite{
+		Name: application.Name,
+		Properties: append([]Property{
+			{Name: "environment", Value: junit.environment},
+			{Name: "version", Value: application.Version},
+		}, junit.properties...),
+	}
+
+	instances := append(append([]*common.Instance{}, application.SuccessfulInstances...), application.FailedInstances...)
+	if len(instances) == 0 {
+		testCase := &TestCase{Name: "instances", Classname: application.Name}
+		if len(application.Errors) > 0 {
+			testCase.Failure = &Failure{Message: applicationErrors(application), Type: "instances"}
+		}
+		suite.add(testCase)
+	}
+
+	for _, instance := range instances {
+		for _, phase := range phases {
+			suite.add(newTestCase(application, instance, phase))
+		}
+	}
+
+	junit.Suites = append(junit.Suites, suite)
+	junit.Tests += suite.Tests
+	junit.Failed += suite.Failed
+	junit.Skipped += suite.Skipped
+	junit.Time += suite.Time
+}
+
+// Write writes junit report to filename
+func (junit *JUnit) Write(filename string) error {
+	data, err := xml.MarshalIndent(junit, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func (suite *TestSuite) add(testCase *TestCase) {
+	suite.Cases = append(suite.Cases, testCase)
+	suite.Tests++
+	suite.Time += testCase.Time
+	if testCase.Failure != nil {
+		suite.Failed++
+	} else if testCase.Skipped != nil {
+		suite.Skipped++
+	}
+}
+
+func newTestCase(application *common.Application, instance *common.Instance, phase string) *TestCase {
+	testCase := &TestCase{
+		Name:      instance.Hostname() + " " + phase,
+		Classname: application.Name,
+	}
+
+	if phase == common.PhaseConnect {
+		testCase.Time = instance.ConnectDuration().Seconds()
+		if err := instance.ConnectError(); err != nil {
+			testCase.Failure = &Failure{Message: err.Error(), Type: phase}
+		} else if !instance.Connected() {
+			testCase.Failure = &Failure{Message: "not connected", Type: phase}
+		}
+		return testCase
+	}
+
+	command := instance.Command(phase)
+	if command == nil {
+		testCase.Skipped = &Skipped{Message: "not executed"}
+		return testCase
+	}
+
+	if !command.Finished.IsZero() {
+		testCase.Time = command.Finished.Sub(command.Started).Seconds()
+	}
+	testCase.SystemOut = command.Combined.String()
+	if command.Error != nil {
+		testCase.Failure = &Failure{
+			Message: command.Description + " failed: " + command.Error.Error(),
+			Type:    phase,
+			Text:    command.Command,
+		}
+	}
+	return testCase
+}
+
+func applicationErrors(application *common.Application) string {
+	messages := []string{}
+	for _, err := range application.Errors {
+		messages = append(messages, err.String())
+	}
+	return strings.Join(messages, "; ")
+}
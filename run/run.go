@@ -0,0 +1,204 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package run
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+const (
+	timeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var (
+	runDirectoryRegexp = regexp.MustCompile(`^\d{8}T\d{6}-\d+$`)
+	unsafeCharacters   = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// Run is a directory with transcripts of all executed commands and a summary of a single run
+type Run struct {
+	Directory   string
+	Environment string
+	Username    string
+	Started     time.Time
+}
+
+// New creates a new run directory in base
+func New(base string, environment string, username string) (*Run, error) {
+	started := time.Now()
+	directory := filepath.Join(base, fmt.Sprintf("%s-%d", started.Format("20060102T150405"), os.Getpid()))
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	return &Run{
+		Directory:   directory,
+		Environment: environment,
+		Username:    username,
+		Started:     started,
+	}, nil
+}
+
+// Write writes a transcript file per instance and the run summary
+func (run *Run) Write(applications []*common.Application, exitCode int, notes ...string) error {
+	for _, application := range applications {
+		for _, instance := range instances(application) {
+			if err := run.writeTranscript(application, instance); err != nil {
+				return err
+			}
+		}
+	}
+	return run.writeSummary(applications, exitCode, notes)
+}
+
+func (run *Run) writeTranscript(application *common.Application, instance *common.Instance) error {
+	filename := filepath.Join(run.Directory, unsafeCharacters.ReplaceAllString(application.Name+"_"+instance.Hostname(), "_")+".log")
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "application: %s\nversion:     %s\nhostname:    %s\n", application.Name, application.Version, instance.Hostname())
+	fmt.Fprintf(f, "connect:     %s", instance.ConnectDuration())
+	if err := instance.ConnectError(); err != nil {
+		fmt.Fprintf(f, " (%v)", err)
+	}
+	fmt.Fprintln(f)
+
+	for _, command := range instance.Commands {
+		fmt.Fprintf(f, "\n=== %s: %s\n", command.Phase, command.Command)
+		fmt.Fprintf(f, "description: %s\n", command.Description)
+		fmt.Fprintf(f, "started:     %s\n", formatTime(command.Started))
+		fmt.Fprintf(f, "finished:    %s\n", formatTime(command.Finished))
+		if !command.Finished.IsZero() {
+			fmt.Fprintf(f, "duration:    %s\n", command.Finished.Sub(command.Started))
+		}
+		fmt.Fprintf(f, "exit status: %d\n", command.ExitStatus())
+		if command.Error != nil {
+			fmt.Fprintf(f, "error:       %v\n", command.Error)
+		}
+		writeOutput(f, "stdout", command.Stdout.String())
+		writeOutput(f, "stderr", command.Stderr.String())
+	}
+
+	if len(instance.Errors) > 0 {
+		fmt.Fprintln(f, "\n=== errors")
+		for _, err := range instance.Errors {
+			fmt.Fprintln(f, err.String())
+		}
+	}
+	return nil
+}
+
+func (run *Run) writeSummary(applications []*common.Application, exitCode int, notes []string) error {
+	f, err := os.Create(filepath.Join(run.Directory, "summary.txt"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "user:        %s\nenvironment: %s\nstarted:     %s\nfinished:    %s\nexit code:   %d\n", run.Username, run.Environment, formatTime(run.Started), formatTime(time.Now()), exitCode)
+	for _, note := range notes {
+		fmt.Fprintln(f, note)
+	}
+
+	for _, application := range applications {
+		fmt.Fprintf(f, "\n- name:       %s\n  version:    %s\n", application.Name, application.Version)
+		for _, err := range application.Errors {
+			fmt.Fprintf(f, "  error:      %s\n", err.String())
+		}
+		for _, instance := range instances(application) {
+			fmt.Fprintf(f, "  - hostname: %s\n    current:  %s\n    result:   %s\n", instance.Hostname(), instance.CurrentVersion().String(), result(instance))
+		}
+	}
+	return nil
+}
+
+// Prune removes the oldest run directories in base, so that only retention run directories are kept
+func Prune(base string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(base)
+	if err != nil {
+		return err
+	}
+
+	var directories []string
+	for _, file := range files {
+		if file.IsDir() && runDirectoryRegexp.MatchString(file.Name()) {
+			directories = append(directories, file.Name())
+		}
+	}
+	sort.Strings(directories)
+
+	for len(directories) > retention {
+		if err := os.RemoveAll(filepath.Join(base, directories[0])); err != nil {
+			return err
+		}
+		directories = directories[1:]
+	}
+	return nil
+}
+
+func instances(application *common.Application) []*common.Instance {
+	return append(append([]*common.Instance{}, application.SuccessfulInstances...), application.FailedInstances...)
+}
+
+func result(instance *common.Instance) string {
+	if !instance.Connected() {
+		return "not connected"
+	}
+	command := instance.Command(common.PhaseSwitch)
+	if command == nil {
+		if len(instance.Errors) > 0 {
+			return "failed"
+		}
+		return "not switched"
+	} else if command.Error != nil {
+		return "switch failed"
+	}
+	return "switched"
+}
+
+func writeOutput(w io.Writer, name string, output string) {
+	if output == "" {
+		fmt.Fprintf(w, "%s:      <empty>\n", name)
+		return
+	}
+	fmt.Fprintf(w, "%s:\n%s", name, output)
+	if !strings.HasSuffix(output, "\n") {
+		fmt.Fprintln(w)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeFormat)
+}
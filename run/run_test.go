@@ -0,0 +1,131 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package run
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+func TestWrite(t *testing.T) {
+	base, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	run, err := New(base, "prod", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !runDirectoryRegexp.MatchString(filepath.Base(run.Directory)) {
+		t.Errorf("run directory %s doesn't match %s", run.Directory, runDirectoryRegexp)
+	}
+
+	instance := common.NewInstance(zap.NewNop().Sugar(), "web-1.example.com", "22", "deploy", false)
+	started := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	command := instance.NewCommand(common.PhaseSwitch, "switch -a web -v 1.1", "switch application")
+	command.Started, command.Finished = started, started.Add(2*time.Second)
+	command.Stdout.WriteString("switched")
+	command.Error = errors.New("exit status 1")
+	application := common.NewApplication("web", "1.1")
+	application.SuccessfulInstances = []*common.Instance{instance}
+
+	if err := run.Write([]*common.Application{application}, 1, "config: config.yml@abc"); err != nil {
+		t.Fatalf("Write returned %v", err)
+	}
+
+	transcript, err := ioutil.ReadFile(filepath.Join(run.Directory, "web_web-1.example.com.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"application: web\n",
+		"hostname:    web-1.example.com\n",
+		"=== switch: switch -a web -v 1.1\n",
+		"started:     2020-07-01T12:00:00.000Z\n",
+		"duration:    2s\n",
+		"error:       exit status 1\n",
+		"stdout:\nswitched\n",
+		"stderr:      <empty>\n",
+	} {
+		if !strings.Contains(string(transcript), line) {
+			t.Errorf("transcript doesn't contain %q:\n%s", line, transcript)
+		}
+	}
+
+	summary, err := ioutil.ReadFile(filepath.Join(run.Directory, "summary.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"user:        alice\n",
+		"environment: prod\n",
+		"exit code:   1\n",
+		"config: config.yml@abc\n",
+		"- name:       web\n  version:    1.1\n",
+		"  - hostname: web-1.example.com\n",
+		"    result:   not connected\n",
+	} {
+		if !strings.Contains(string(summary), line) {
+			t.Errorf("summary doesn't contain %q:\n%s", line, summary)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	base, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	for _, name := range []string{"20200701T120000-10", "20200701T130000-11", "20200702T090000-12", "20200703T090000-13", "other", "20200701T110000-9.log"} {
+		if err := os.Mkdir(filepath.Join(base, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Prune(base, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prune(base, 2); err != nil {
+		t.Fatalf("Prune returned %v", err)
+	}
+
+	files, err := ioutil.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	expected := []string{"20200701T110000-9.log", "20200702T090000-12", "20200703T090000-13", "other"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Prune kept %v, expected %v", names, expected)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/lock"
+	"github.com/lscheidler/switchctl/progress"
+)
+
+const (
+	// plainCountdownInterval is the interval of countdown lines in plain progress output
+	plainCountdownInterval = 10 * time.Minute
+	// refreshInterval is the interval to refresh deploy locks, which keeps ssh connections alive as well
+	refreshInterval = 5 * time.Minute
+)
+
+// waitForSchedule waits until the scheduled time of the switch with a countdown and prepares the applications afterwards:
+// prefetch is re-run, if the wait exceeded the prefetch-after option, and applications with drifted versions are skipped.
+// It returns false, if applications were skipped.
+func waitForSchedule(args *cli.Arguments, p *progress.Progress, locker *lock.Locker, locked []*common.Application) bool {
+	start := time.Now()
+	slog.Infof("Waiting until %s to switch", args.Schedule.Format(time.RFC3339))
+	fmt.Printf("Switching at %s, enter <control>+c to cancel\n", args.Schedule.Format("2006-01-02 15:04:05 MST"))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastRefresh := start
+	lastLine := start
+	for now := range ticker.C {
+		remaining := args.Schedule.Sub(now)
+		if remaining <= 0 {
+			break
+		}
+
+		switch args.Progress {
+		case "fancy":
+			fmt.Printf("\rswitching in %-12s", remaining.Truncate(time.Second))
+		case "plain":
+			if now.Sub(lastLine) >= plainCountdownInterval {
+				fmt.Printf("%s switching in %s\n", now.Format(time.RFC3339), remaining.Truncate(time.Second))
+				lastLine = now
+			}
+		}
+
+		if now.Sub(lastRefresh) >= refreshInterval {
+			for _, application := range locked {
+				locker.Refresh(application)
+			}
+			lastRefresh = now
+		}
+	}
+	if args.Progress == "fancy" {
+		fmt.Println()
+	}
+
+	if args.PrefetchAfter > 0 && time.Since(start) >= args.PrefetchAfter {
+		slog.Info("Re-running prefetch after waiting ", time.Since(start).Truncate(time.Second))
+		fmt.Println("Re-running prefetch...")
+		p.Prefetch(args.Environment)
+	}
+
+	failed := len(p.FailedApplications)
+	p.CheckDrift()
+	for _, application := range p.FailedApplications[failed:] {
+		fmt.Printf("Skipping %s: %v\n", application.Name, application.Errors)
+	}
+	return len(p.FailedApplications) == failed
+}
+
+// reloadFreezes loads the config again before a scheduled switch, so freeze windows, which were added while waiting,
+// are checked as well. config is returned, if it cannot be loaded.
+func reloadFreezes(args *cli.Arguments, config *conf.Config) *conf.Config {
+	location := conf.Filename(args.Config)
+	if config.Source != nil {
+		location = config.Source.Location
+	}
+	reloaded, err := conf.Load(location)
+	if err != nil {
+		slog.Warnf("Failed to reload config to check freeze windows: %v", err)
+		return config
+	}
+	return reloaded
+}
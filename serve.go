@@ -0,0 +1,80 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/agtorre/gocolorize"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/server"
+)
+
+// uiListen listens on a random local port
+const uiListen = "127.0.0.1:0"
+
+// serve serves the http api until it fails
+func serve(args *cli.Arguments, config *conf.Config) int {
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	s, err := server.New(slog, config, args.Listen, args.Workers)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to start server:"), err)
+		return 1
+	}
+
+	slog.Infof("Serving http api on %s", s.Listen())
+	fmt.Println("Serving http api on", s.Listen())
+	if err := s.ListenAndServe(); err != nil {
+		slog.Errorf("Failed to serve http api: %v", err)
+		fmt.Println(cred.Paint("Failed to serve http api:"), err)
+	}
+	return 1
+}
+
+// ui serves the web ui on a local port, which is opened with a one-time login url
+func ui(args *cli.Arguments, config *conf.Config) int {
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	listen := args.Listen
+	if listen == "" {
+		listen = uiListen
+	}
+	s, err := server.New(slog, config, listen, args.Workers)
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to start web ui:"), err)
+		return 1
+	}
+	token := s.EnableUI(common.CurrentUsername())
+
+	listener, err := net.Listen("tcp", s.Listen())
+	if err != nil {
+		fmt.Println(cred.Paint("Failed to start web ui:"), err)
+		return 1
+	}
+
+	slog.Infof("Serving web ui on %s", listener.Addr())
+	fmt.Printf("Open http://%s/login?token=%s (the link can be used once)\n", listener.Addr(), token)
+	if err := s.Serve(listener); err != nil {
+		slog.Errorf("Failed to serve web ui: %v", err)
+		fmt.Println(cred.Paint("Failed to serve web ui:"), err)
+	}
+	return 1
+}
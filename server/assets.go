@@ -0,0 +1,295 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+// assets of the web ui, which are compiled into the binary
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>switchctl</title>
+  <link rel="stylesheet" href="/style.css">
+</head>
+<body>
+  <h1>switchctl</h1>
+
+  <section id="compose">
+    <h2>Release</h2>
+    <label>Environment <select id="environment"></select></label>
+    <label><input type="checkbox" id="dryrun"> dryrun</label>
+    <table id="applications">
+      <thead><tr><th></th><th>Application</th><th>Version</th><th>Current versions</th></tr></thead>
+      <tbody></tbody>
+    </table>
+    <label>Other application <input id="other" placeholder="application:version"></label>
+    <label>Freeze override <input id="overrideFreeze" placeholder="justification"></label>
+    <button id="submit">Review plan</button>
+    <p id="message" class="error"></p>
+  </section>
+
+  <section id="deployment" hidden>
+    <h2>Deployment <span id="deployment-id"></span> <span id="state" class="state"></span></h2>
+    <div id="plan"></div>
+    <button id="start" hidden>Approve and switch</button>
+    <button id="cancel" hidden>Cancel</button>
+    <table id="progress">
+      <thead><tr><th>Application</th><th>Hostname</th><th>Status</th><th>Duration</th></tr></thead>
+      <tbody></tbody>
+    </table>
+  </section>
+
+  <section id="history">
+    <h2>History</h2>
+    <table>
+      <thead><tr><th>Created</th><th>Environment</th><th>Applications</th><th>State</th><th>Created by</th></tr></thead>
+      <tbody></tbody>
+    </table>
+  </section>
+
+  <script src="/app.js"></script>
+</body>
+</html>
+`
+
+const styleCSS = `body { font-family: sans-serif; margin: 2em; color: #222; }
+section { margin-bottom: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+label { margin-right: 1em; }
+button { margin-right: 0.5em; }
+.error, .failed, .skipped { color: #c00; }
+.succeeded, .ok { color: #080; }
+.running, .loading, .planned { color: #a60; }
+.name { color: #a60; font-weight: bold; }
+tr.selectable { cursor: pointer; }
+`
+
+const appJS = `"use strict";
+
+var current = null;
+var events = null;
+
+function el(tag, text, className) {
+  var e = document.createElement(tag);
+  if (text !== undefined && text !== null) { e.textContent = text; }
+  if (className) { e.className = className; }
+  return e;
+}
+
+function api(method, path, body) {
+  var options = { method: method, credentials: "same-origin", headers: {} };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  return fetch(path, options).then(function (response) {
+    return response.json().then(function (data) {
+      if (!response.ok) { throw new Error(data.error || response.statusText); }
+      return data;
+    });
+  });
+}
+
+function message(text) {
+  document.getElementById("message").textContent = text || "";
+}
+
+function loadApplications() {
+  api("GET", "/applications").then(function (configured) {
+    var select = document.getElementById("environment");
+    configured.environments.forEach(function (environment) {
+      select.appendChild(el("option", environment));
+    });
+    var tbody = document.querySelector("#applications tbody");
+    configured.applications.forEach(function (application) {
+      if (application.regexp) { return; }
+      var row = el("tr");
+      row.dataset.environments = application.environments.join(" ");
+      var checkbox = el("input");
+      checkbox.type = "checkbox";
+      checkbox.value = application.name;
+      var cell = el("td");
+      cell.appendChild(checkbox);
+      row.appendChild(cell);
+      row.appendChild(el("td", application.name));
+      var version = el("input");
+      version.placeholder = "version";
+      cell = el("td");
+      cell.appendChild(version);
+      row.appendChild(cell);
+      var versions = el("td");
+      var button = el("button", "show");
+      button.onclick = function () { loadVersions(application.name, versions); };
+      versions.appendChild(button);
+      row.appendChild(versions);
+      tbody.appendChild(row);
+    });
+    select.onchange = filterApplications;
+    filterApplications();
+  }).catch(function (err) { message(err.message); });
+}
+
+function filterApplications() {
+  var environment = document.getElementById("environment").value;
+  document.querySelectorAll("#applications tbody tr").forEach(function (row) {
+    row.hidden = row.dataset.environments.split(" ").indexOf(environment) < 0;
+  });
+}
+
+function loadVersions(name, cell) {
+  var environment = document.getElementById("environment").value;
+  cell.textContent = "loading...";
+  api("GET", "/versions?environment=" + encodeURIComponent(environment) + "&application=" + encodeURIComponent(name)).then(function (instances) {
+    cell.textContent = "";
+    instances.forEach(function (instance) {
+      var line = el("div", instance.hostname + ": " + instance.current + (instance.error ? " (" + instance.error + ")" : ""));
+      if (instance.error) { line.className = "error"; }
+      cell.appendChild(line);
+    });
+  }).catch(function (err) { cell.textContent = err.message; cell.className = "error"; });
+}
+
+function submit() {
+  message();
+  var applications = [];
+  document.querySelectorAll("#applications tbody tr").forEach(function (row) {
+    var inputs = row.querySelectorAll("input");
+    if (!row.hidden && inputs[0].checked) {
+      applications.push({ name: inputs[0].value, version: inputs[1].value.trim() });
+    }
+  });
+  document.getElementById("other").value.split(",").forEach(function (value) {
+    var parts = value.trim().split(":");
+    if (parts[0]) { applications.push({ name: parts[0], version: parts.slice(1).join(":") }); }
+  });
+
+  api("POST", "/deployments", {
+    environment: document.getElementById("environment").value,
+    applications: applications,
+    dryrun: document.getElementById("dryrun").checked,
+    overrideFreeze: document.getElementById("overrideFreeze").value
+  }).then(function (deployment) {
+    watch(deployment.id);
+    loadHistory();
+  }).catch(function (err) { message(err.message); });
+}
+
+function watch(id) {
+  if (events) { events.close(); }
+  current = id;
+  document.getElementById("deployment").hidden = false;
+  document.getElementById("deployment-id").textContent = id;
+  document.getElementById("plan").textContent = "";
+  document.querySelector("#progress tbody").textContent = "";
+  events = new EventSource("/deployments/" + id + "/events");
+  events.addEventListener("state", function (e) { setState(JSON.parse(e.data)); });
+  events.addEventListener("instance_started", function (e) { instanceRow(JSON.parse(e.data), "running"); });
+  events.addEventListener("instance_finished", function (e) {
+    var event = JSON.parse(e.data);
+    instanceRow(event, event.failed ? "failed" : "ok", event.error);
+  });
+  events.onerror = function () { if (events.readyState === EventSource.CLOSED) { events = null; } };
+}
+
+function setState(event) {
+  var state = document.getElementById("state");
+  state.textContent = event.state + (event.error ? ": " + event.error : "");
+  state.className = "state " + event.state;
+  document.getElementById("start").hidden = event.state !== "planned";
+  document.getElementById("cancel").hidden = event.state !== "planned" && event.state !== "loading";
+  if (event.state === "planned") { loadPlan(); }
+  if (["succeeded", "failed", "cancelled"].indexOf(event.state) >= 0) {
+    if (events) { events.close(); events = null; }
+    loadHistory();
+  }
+}
+
+function instanceRow(event, status, error) {
+  var key = event.application + " " + event.hostname;
+  var row = document.querySelector("#progress tbody tr[data-key='" + CSS.escape(key) + "']");
+  if (!row) {
+    row = el("tr");
+    row.dataset.key = key;
+    document.querySelector("#progress tbody").appendChild(row);
+  }
+  row.textContent = "";
+  row.appendChild(el("td", event.application));
+  row.appendChild(el("td", event.hostname));
+  row.appendChild(el("td", status + (error ? ": " + error : ""), status));
+  row.appendChild(el("td", event.duration ? event.duration.toFixed(1) + "s" : ""));
+}
+
+function loadPlan() {
+  api("GET", "/deployments/" + current + "/plan").then(function (plan) {
+    var div = document.getElementById("plan");
+    div.textContent = "";
+    var switching = plan.applications.filter(function (a) { return !a.skipped; });
+    var skipped = plan.applications.filter(function (a) { return a.skipped; });
+    renderApplications(div, "Going to switch following applications:", switching);
+    renderApplications(div, "Following applications are going to be skipped:", skipped);
+  }).catch(function (err) { message(err.message); });
+}
+
+function renderApplications(div, title, applications) {
+  if (applications.length === 0) { return; }
+  div.appendChild(el("p", title));
+  var list = el("ul");
+  applications.forEach(function (application) {
+    var item = el("li");
+    item.appendChild(el("span", application.name, application.skipped ? "skipped" : "name"));
+    item.appendChild(document.createTextNode(" " + application.version));
+    (application.errors || []).forEach(function (error) { item.appendChild(el("div", error, "error")); });
+    var instances = el("ul");
+    (application.instances || []).forEach(function (instance) {
+      var line = el("li", instance.hostname + " current: " + instance.current + (instance.skipped ? " (skipping...)" : ""), instance.skipped ? "skipped" : "");
+      (instance.errors || []).forEach(function (error) { line.appendChild(el("div", error, "error")); });
+      instances.appendChild(line);
+    });
+    item.appendChild(instances);
+    list.appendChild(item);
+  });
+  div.appendChild(list);
+}
+
+function loadHistory() {
+  api("GET", "/deployments").then(function (deployments) {
+    var tbody = document.querySelector("#history tbody");
+    tbody.textContent = "";
+    deployments.forEach(function (deployment) {
+      var row = el("tr", null, "selectable");
+      row.appendChild(el("td", new Date(deployment.createdAt).toLocaleString()));
+      row.appendChild(el("td", deployment.environment));
+      row.appendChild(el("td", deployment.applications.map(function (a) { return a.name + ":" + a.version; }).join(", ")));
+      row.appendChild(el("td", deployment.state, deployment.state));
+      row.appendChild(el("td", deployment.createdBy));
+      row.onclick = function () { watch(deployment.id); };
+      tbody.appendChild(row);
+    });
+  });
+}
+
+document.getElementById("submit").onclick = submit;
+document.getElementById("start").onclick = function () {
+  api("POST", "/deployments/" + current + "/start").catch(function (err) { message(err.message); });
+};
+document.getElementById("cancel").onclick = function () {
+  api("POST", "/deployments/" + current + "/cancel").catch(function (err) { message(err.message); });
+};
+
+loadApplications();
+loadHistory();
+`
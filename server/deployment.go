@@ -0,0 +1,196 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/lock"
+	"github.com/lscheidler/switchctl/notify"
+	"github.com/lscheidler/switchctl/plan"
+	"github.com/lscheidler/switchctl/progress"
+)
+
+const (
+	StateLoading   = "loading"
+	StatePlanned   = "planned"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// Request submits a deployment, applications and environment are taken from plan, if it is set
+type Request struct {
+	Environment    string              `json:"environment"`
+	Applications   []*plan.Application `json:"applications"`
+	Strategy       string              `json:"strategy"`
+	Dryrun         bool                `json:"dryrun"`
+	OverrideFreeze string              `json:"overrideFreeze"`
+	Plan           *plan.Plan          `json:"plan"`
+}
+
+// Deployment is a switch of applications in an environment submitted via the http api
+type Deployment struct {
+	ID             string              `json:"id"`
+	Environment    string              `json:"environment"`
+	Applications   []*plan.Application `json:"applications"`
+	Strategy       string              `json:"strategy,omitempty"`
+	Dryrun         bool                `json:"dryrun"`
+	State          string              `json:"state"`
+	CreatedBy      string              `json:"createdBy"`
+	CreatedAt      time.Time           `json:"createdAt"`
+	StartedBy      string              `json:"startedBy,omitempty"`
+	StartedAt      *time.Time          `json:"startedAt,omitempty"`
+	FinishedAt     *time.Time          `json:"finishedAt,omitempty"`
+	ExitCode       *int                `json:"exitCode,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	Approvers      []string            `json:"approvers,omitempty"`
+	FreezeOverride string              `json:"freezeOverride,omitempty"`
+
+	mutex        sync.Mutex
+	approved     *plan.Plan
+	loaded       bool
+	applications common.Applications
+	progress     *progress.Progress
+	locker       *lock.Locker
+	locked       []*common.Application
+	notifier     *notify.Notifier
+	keys         []string
+	released     bool // protected by the mutex of the server
+	events       []*Event
+	changed      chan struct{}
+}
+
+// Plan is the information about applications and instances, which is shown before a deployment is started
+type Plan struct {
+	Applications []*PlanApplication `json:"applications"`
+}
+
+type PlanApplication struct {
+	Name      string          `json:"name"`
+	Version   string          `json:"version"`
+	Skipped   bool            `json:"skipped"`
+	Errors    []string        `json:"errors,omitempty"`
+	Instances []*PlanInstance `json:"instances"`
+}
+
+type PlanInstance struct {
+	Hostname string   `json:"hostname"`
+	Current  string   `json:"current"`
+	Skipped  bool     `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// MarshalJSON marshals deployment, while it is locked
+func (deployment *Deployment) MarshalJSON() ([]byte, error) {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	type plain Deployment
+	return json.Marshal((*plain)(deployment))
+}
+
+// Finished returns true, if the deployment reached a final state
+func (deployment *Deployment) Finished() bool {
+	switch deployment.State {
+	case StateSucceeded, StateFailed, StateCancelled:
+		return true
+	}
+	return false
+}
+
+// Plan returns the loaded applications and instances of deployment
+func (deployment *Deployment) Plan() *Plan {
+	result := &Plan{}
+	for _, application := range deployment.progress.SuccessfulApplications {
+		result.Applications = append(result.Applications, newPlanApplication(application, false))
+	}
+	for _, application := range deployment.progress.FailedApplications {
+		result.Applications = append(result.Applications, newPlanApplication(application, true))
+	}
+	return result
+}
+
+func newPlanApplication(application *common.Application, skipped bool) *PlanApplication {
+	result := &PlanApplication{
+		Name:    application.Name,
+		Version: application.Version,
+		Skipped: skipped,
+		Errors:  errorMessages(application.Errors),
+	}
+	for _, instance := range application.SuccessfulInstances {
+		result.Instances = append(result.Instances, newPlanInstance(instance, skipped))
+	}
+	for _, instance := range application.FailedInstances {
+		result.Instances = append(result.Instances, newPlanInstance(instance, true))
+	}
+	return result
+}
+
+func newPlanInstance(instance *common.Instance, skipped bool) *PlanInstance {
+	return &PlanInstance{
+		Hostname: instance.Hostname(),
+		Current:  instance.CurrentVersion().String(),
+		Skipped:  skipped,
+		Errors:   errorMessages(instance.Errors),
+	}
+}
+
+func errorMessages(errors []*common.Error) []string {
+	var messages []string
+	for _, err := range errors {
+		messages = append(messages, err.String())
+	}
+	return messages
+}
+
+// setState changes the state of deployment and publishes a state event, deployment must be locked
+func (deployment *Deployment) setState(state string) {
+	deployment.State = state
+	now := time.Now().UTC()
+	if state == StateRunning {
+		deployment.StartedAt = &now
+	} else if deployment.Finished() {
+		deployment.FinishedAt = &now
+	}
+	deployment.publish(&Event{Type: EventState, State: state, Error: deployment.Error})
+}
+
+// publish appends event and wakes up all waiting event streams, deployment must be locked
+func (deployment *Deployment) publish(event *Event) {
+	event.ID = len(deployment.events) + 1
+	event.Time = time.Now().UTC()
+	deployment.events = append(deployment.events, event)
+
+	close(deployment.changed)
+	deployment.changed = make(chan struct{})
+}
+
+// eventsSince returns the events after the event with id since, a channel, which is closed on the next event,
+// and if the deployment is finished
+func (deployment *Deployment) eventsSince(since int) ([]*Event, <-chan struct{}, bool) {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	if since < 0 || since > len(deployment.events) {
+		since = 0
+	}
+	return append([]*Event{}, deployment.events[since:]...), deployment.changed, deployment.Finished()
+}
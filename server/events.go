@@ -0,0 +1,101 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"time"
+
+	"github.com/lscheidler/switchctl/common"
+)
+
+const (
+	EventState               = "state"
+	EventLoadStarted         = "load_started"
+	EventApplicationLoaded   = "application_loaded"
+	EventLoadFinished        = "load_finished"
+	EventSwitchStarted       = "switch_started"
+	EventInstanceStarted     = "instance_started"
+	EventInstanceFinished    = "instance_finished"
+	EventApplicationFinished = "application_finished"
+	EventSwitchFinished      = "switch_finished"
+)
+
+// Event is a progress event of a deployment, which is streamed as server-sent event
+type Event struct {
+	ID          int       `json:"id"`
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	State       string    `json:"state,omitempty"`
+	Application string    `json:"application,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Failed      bool      `json:"failed,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// eventView publishes the progress of loading and switching applications as events of deployment
+type eventView struct {
+	deployment *Deployment
+}
+
+func (view *eventView) publish(event *Event) {
+	view.deployment.mutex.Lock()
+	defer view.deployment.mutex.Unlock()
+	view.deployment.publish(event)
+}
+
+func (view *eventView) LoadStarted(applications []*common.Application) {
+	view.publish(&Event{Type: EventLoadStarted})
+}
+
+func (view *eventView) ApplicationLoaded(application *common.Application, duration time.Duration, err error) {
+	event := &Event{Type: EventApplicationLoaded, Application: application.Name, Version: application.Version, Duration: duration.Seconds()}
+	if err != nil {
+		event.Failed = true
+		event.Error = err.Error()
+	}
+	view.publish(event)
+}
+
+func (view *eventView) LoadFinished() {
+	view.publish(&Event{Type: EventLoadFinished})
+}
+
+func (view *eventView) SwitchStarted(applications []*common.Application) {
+	view.publish(&Event{Type: EventSwitchStarted})
+}
+
+func (view *eventView) InstanceStarted(application *common.Application, instance *common.Instance) {
+	view.publish(&Event{Type: EventInstanceStarted, Application: application.Name, Version: application.Version, Hostname: instance.Hostname()})
+}
+
+func (view *eventView) InstanceFinished(application *common.Application, instance *common.Instance, command *common.Command, duration time.Duration) {
+	event := &Event{Type: EventInstanceFinished, Application: application.Name, Version: application.Version, Hostname: instance.Hostname(), Duration: duration.Seconds()}
+	if command.Error != nil {
+		event.Failed = true
+		event.Error = command.Description + " failed: " + command.Error.Error()
+	}
+	view.publish(event)
+}
+
+func (view *eventView) ApplicationFinished(application *common.Application, failed bool) {
+	view.publish(&Event{Type: EventApplicationFinished, Application: application.Name, Version: application.Version, Failed: failed})
+}
+
+func (view *eventView) SwitchFinished() {
+	view.publish(&Event{Type: EventSwitchFinished})
+}
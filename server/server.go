@@ -0,0 +1,623 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/freeze"
+	"github.com/lscheidler/switchctl/lock"
+	"github.com/lscheidler/switchctl/notify"
+	"github.com/lscheidler/switchctl/plan"
+	"github.com/lscheidler/switchctl/policy"
+	"github.com/lscheidler/switchctl/progress"
+)
+
+const (
+	defaultListen  = "127.0.0.1:8080"
+	defaultHistory = 100
+
+	notificationTimeout = 15 * time.Second
+)
+
+var (
+	// names of environments and applications are used in templates of hostnames and discovery commands
+	namePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+:~-]*$`)
+)
+
+// Server serves the http api to submit, start, cancel and monitor deployments
+type Server struct {
+	slog    *zap.SugaredLogger
+	config  *conf.Config
+	listen  string
+	tokens  []*conf.Token
+	history int
+	workers int
+
+	mutex       sync.Mutex
+	deployments []*Deployment
+	active      map[string]*Deployment
+
+	ui       bool
+	logins   map[string]string
+	sessions map[string]string
+	groups   map[string][]string
+}
+
+// Error is an error of the http api with a http status code
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+func New(slog *zap.SugaredLogger, config *conf.Config, listen string, workers int) (*Server, error) {
+	server := &Server{
+		slog:    slog,
+		config:  config,
+		listen:  defaultListen,
+		history: defaultHistory,
+		workers: workers,
+		active:  map[string]*Deployment{},
+
+		logins:   map[string]string{},
+		sessions: map[string]string{},
+		groups:   map[string][]string{},
+	}
+	if config.Server != nil {
+		if config.Server.Listen != "" {
+			server.listen = config.Server.Listen
+		}
+		if config.Server.History > 0 {
+			server.history = config.Server.History
+		}
+		server.tokens = config.Server.Tokens
+	}
+	if listen != "" {
+		server.listen = listen
+	}
+
+	for _, token := range server.tokens {
+		if token.Name == "" || token.Token == "" {
+			return nil, errors.New("server.tokens require name and token")
+		}
+	}
+	return server, nil
+}
+
+// Listen returns the address the server listens on
+func (server *Server) Listen() string {
+	return server.listen
+}
+
+func (server *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", server.listen)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// Serve serves the http api and the web ui, if it is enabled, on listener
+func (server *Server) Serve(listener net.Listener) error {
+	if len(server.tokens) == 0 && !server.ui {
+		return errors.New("no tokens configured in server.tokens")
+	}
+	return http.Serve(listener, server.Handler())
+}
+
+// Handler returns the handler of the http api
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/deployments", server.authenticated(server.handleDeployments))
+	mux.HandleFunc("/deployments/", server.authenticated(server.handleDeployment))
+	if server.ui {
+		server.handleUI(mux)
+	}
+	return mux
+}
+
+// Client returns the name of the token or session, which authenticates request, or an empty string
+func (server *Server) Client(request *http.Request) string {
+	if cookie, err := request.Cookie(sessionCookie); err == nil {
+		server.mutex.Lock()
+		name := server.sessions[cookie.Value]
+		server.mutex.Unlock()
+		if name != "" {
+			return name
+		}
+	}
+
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	bearer := []byte(strings.TrimPrefix(header, "Bearer "))
+	for _, token := range server.tokens {
+		if subtle.ConstantTimeCompare(bearer, []byte(token.Token)) == 1 {
+			return token.Name
+		}
+	}
+	return ""
+}
+
+// clientGroups returns the groups of client, only users of web ui sessions have groups
+func (server *Server) clientGroups(client string) []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.groups[client]
+}
+
+func (server *Server) authenticated(handler func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		client := server.Client(request)
+		if client == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="switchctl"`)
+			writeError(w, &Error{Status: http.StatusUnauthorized, Message: "invalid or missing bearer token"})
+			return
+		}
+		handler(w, request, client)
+	}
+}
+
+// handleDeployments lists deployments (GET) or submits a deployment (POST)
+func (server *Server) handleDeployments(w http.ResponseWriter, request *http.Request, client string) {
+	switch request.Method {
+	case http.MethodGet:
+		server.mutex.Lock()
+		deployments := append([]*Deployment{}, server.deployments...)
+		server.mutex.Unlock()
+
+		result := []*Deployment{}
+		for i := len(deployments) - 1; i >= 0; i-- {
+			result = append(result, deployments[i])
+		}
+		writeJSON(w, http.StatusOK, result)
+	case http.MethodPost:
+		var r Request
+		if err := json.NewDecoder(request.Body).Decode(&r); err != nil {
+			writeError(w, &Error{Status: http.StatusBadRequest, Message: "invalid request: " + err.Error()})
+			return
+		}
+		deployment, err := server.Submit(&r, client)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, deployment)
+	default:
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Message: "method not allowed"})
+	}
+}
+
+// handleDeployment handles /deployments/<id>[/plan|/start|/cancel|/events]
+func (server *Server) handleDeployment(w http.ResponseWriter, request *http.Request, client string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/deployments/"), "/"), "/")
+	deployment := server.Deployment(parts[0])
+	if deployment == nil {
+		writeError(w, &Error{Status: http.StatusNotFound, Message: "deployment not found"})
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+		action = strings.Join(parts[1:], "/")
+	}
+
+	var err error
+	switch {
+	case action == "" && request.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, deployment)
+	case action == "plan" && request.Method == http.MethodGet:
+		var p *Plan
+		if p, err = server.Plan(deployment); err == nil {
+			writeJSON(w, http.StatusOK, p)
+		}
+	case action == "start" && request.Method == http.MethodPost:
+		if err = server.Start(deployment, client); err == nil {
+			writeJSON(w, http.StatusAccepted, deployment)
+		}
+	case action == "cancel" && request.Method == http.MethodPost:
+		if err = server.Cancel(deployment, client); err == nil {
+			writeJSON(w, http.StatusOK, deployment)
+		}
+	case action == "events" && request.Method == http.MethodGet:
+		server.streamEvents(w, request, deployment)
+	case action == "" || action == "plan" || action == "start" || action == "cancel" || action == "events":
+		err = &Error{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	default:
+		err = &Error{Status: http.StatusNotFound, Message: "not found"}
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// streamEvents streams the events of deployment as server-sent events until the deployment is finished,
+// a stream is resumed after the event id in the Last-Event-ID header
+func (server *Server) streamEvents(w http.ResponseWriter, request *http.Request, deployment *Deployment) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &Error{Status: http.StatusInternalServerError, Message: "streaming not supported"})
+		return
+	}
+
+	since, _ := strconv.Atoi(request.Header.Get("Last-Event-ID"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, changed, finished := deployment.eventsSince(since)
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			since = event.ID
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// Deployment returns the deployment with id or nil
+func (server *Server) Deployment(id string) *Deployment {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, deployment := range server.deployments {
+		if deployment.ID == id {
+			return deployment
+		}
+	}
+	return nil
+}
+
+// Submit checks request against approvals, access policy and freeze windows, reserves its applications in the
+// environment and starts loading the applications
+func (server *Server) Submit(request *Request, client string) (*Deployment, error) {
+	deployment := &Deployment{
+		ID:             randomID(),
+		Environment:    request.Environment,
+		Applications:   request.Applications,
+		Strategy:       request.Strategy,
+		Dryrun:         request.Dryrun,
+		State:          StateLoading,
+		CreatedBy:      client,
+		CreatedAt:      time.Now().UTC(),
+		FreezeOverride: strings.TrimSpace(request.OverrideFreeze),
+		approved:       request.Plan,
+		changed:        make(chan struct{}),
+	}
+
+	if request.Plan != nil {
+		deployment.Environment = request.Plan.Environment
+		deployment.Applications = request.Plan.Applications
+	}
+
+	if err := server.check(deployment); err != nil {
+		server.slog.Warnf("Refusing deployment of %s: %v", client, err)
+		return nil, err
+	}
+
+	server.mutex.Lock()
+	for _, application := range deployment.Applications {
+		key := application.Name + "/" + deployment.Environment
+		if active, ok := server.active[key]; ok {
+			server.mutex.Unlock()
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("%s is deployed in %s by deployment %s", application.Name, deployment.Environment, active.ID)}
+		}
+		deployment.keys = append(deployment.keys, key)
+	}
+	for _, key := range deployment.keys {
+		server.active[key] = deployment
+	}
+	server.deployments = append(server.deployments, deployment)
+	server.prune()
+	server.mutex.Unlock()
+
+	notifier, err := notify.New(server.slog, server.config.Notifications, deployment.Environment, deployment.Dryrun)
+	if err != nil {
+		server.slog.Errorf("Failed to create notifier: %v", err)
+		notifier, _ = notify.New(server.slog, nil, deployment.Environment, deployment.Dryrun)
+	}
+	deployment.notifier = notifier
+	for _, application := range deployment.Applications {
+		deployment.applications = append(deployment.applications, common.NewApplication(application.Name, application.Version))
+	}
+	deployment.progress = progress.New(server.slog, server.workers, progress.MultiView(&eventView{deployment: deployment}, notifier.View()))
+	deployment.locker = lock.New(server.slog, server.config.Lock, deployment.Environment)
+
+	server.slog.Infof("%s submitted deployment %s of %v in %s", client, deployment.ID, deployment.applications, deployment.Environment)
+	deployment.mutex.Lock()
+	deployment.publish(&Event{Type: EventState, State: StateLoading})
+	deployment.mutex.Unlock()
+	notifier.Notify(notify.EventRunStarted, notify.Status(notify.StatusPending), deployment.applications...)
+
+	go server.load(deployment)
+	return deployment, nil
+}
+
+// check validates deployment and checks it against approvals, access policy and freeze windows
+func (server *Server) check(deployment *Deployment) error {
+	if deployment.Environment == "" {
+		return &Error{Status: http.StatusBadRequest, Message: "environment must be set"}
+	} else if len(deployment.Applications) == 0 {
+		return &Error{Status: http.StatusBadRequest, Message: "applications must be set"}
+	} else if !namePattern.MatchString(deployment.Environment) {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid environment %q", deployment.Environment)}
+	}
+	for _, application := range deployment.Applications {
+		if application.Name == "" || application.Version == "" {
+			return &Error{Status: http.StatusBadRequest, Message: "applications require name and version"}
+		} else if !namePattern.MatchString(application.Name) {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid application name %q", application.Name)}
+		} else if !versionPattern.MatchString(application.Version) {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid version %q of %s", application.Version, application.Name)}
+		}
+	}
+
+	// instances are switched one after another, other strategies are not supported
+	if deployment.Strategy != "" && deployment.Strategy != "sequential" {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unsupported strategy %q", deployment.Strategy)}
+	}
+	return server.authorize(deployment, deployment.CreatedBy)
+}
+
+// authorize checks deployment against approvals and access policy for client and against active freeze windows.
+// It is called, when a deployment is submitted and again, when it is started, which may be done by another client.
+func (server *Server) authorize(deployment *Deployment, client string) error {
+	var names []string
+	for _, application := range deployment.Applications {
+		names = append(names, application.Name)
+	}
+
+	// access policy and self-approval are evaluated for the authenticated client, not for the server process
+	if deployment.approved != nil {
+		approvers, err := deployment.approved.Verify(server.config.Approvals, client, nil)
+		if err != nil {
+			return &Error{Status: http.StatusForbidden, Message: err.Error()}
+		}
+		deployment.Approvers = approvers
+	} else if !deployment.Dryrun && plan.RequiresApproval(server.config.Approvals, deployment.Environment, names) {
+		return &Error{Status: http.StatusForbidden, Message: "an approved plan is required"}
+	}
+
+	if err := policy.Check(server.config.Access, &policy.Request{User: client, Groups: server.clientGroups(client), Environment: deployment.Environment, Applications: names, Yes: true, Dryrun: deployment.Dryrun}); err != nil {
+		return &Error{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	var active []string
+	for _, name := range names {
+		freezes, err := freeze.Check(server.config.Freezes, deployment.Environment, name, time.Now())
+		if err != nil {
+			return &Error{Status: http.StatusInternalServerError, Message: "failed to check freeze windows: " + err.Error()}
+		}
+		for _, f := range freezes {
+			active = append(active, f.String())
+		}
+	}
+	if len(active) > 0 {
+		if deployment.FreezeOverride == "" {
+			return &Error{Status: http.StatusConflict, Message: strings.Join(active, "; ") + ", set overrideFreeze to override"}
+		}
+		server.slog.Warnf("%s overrides active freeze windows %v: %s", client, active, deployment.FreezeOverride)
+	}
+	return nil
+}
+
+// load loads and prefetches the applications of deployment and acquires their deploy locks
+func (server *Server) load(deployment *Deployment) {
+	args := &cli.Arguments{
+		Applications: deployment.applications,
+		Environment:  deployment.Environment,
+		Dryrun:       deployment.Dryrun,
+	}
+	deployment.progress.Load(args, server.config)
+
+	if !deployment.Dryrun && (server.config.Lock == nil || !server.config.Lock.Disabled) {
+		for _, application := range append([]*common.Application{}, deployment.progress.SuccessfulApplications...) {
+			if err := deployment.locker.Acquire(application); err != nil {
+				server.slog.Warnf("%s: %v", application.Name, err)
+				deployment.progress.Skip(application, err)
+			} else {
+				deployment.locked = append(deployment.locked, application)
+			}
+		}
+	}
+
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	deployment.loaded = true
+	if deployment.State == StateCancelled {
+		server.finish(deployment)
+		return
+	} else if len(deployment.progress.SuccessfulApplications) == 0 {
+		deployment.Error = "all applications failed"
+		server.finish(deployment)
+		deployment.setState(StateFailed)
+		return
+	}
+	deployment.setState(StatePlanned)
+}
+
+// Plan returns the plan of a loaded deployment
+func (server *Server) Plan(deployment *Deployment) (*Plan, error) {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	if !deployment.loaded {
+		return nil, &Error{Status: http.StatusConflict, Message: "deployment is loading"}
+	}
+	return deployment.Plan(), nil
+}
+
+// Start switches the applications of a planned deployment
+func (server *Server) Start(deployment *Deployment, client string) error {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	if deployment.State != StatePlanned {
+		return &Error{Status: http.StatusConflict, Message: "deployment is " + deployment.State}
+	}
+	// freeze windows may have started and plans may have expired since the deployment was submitted
+	if err := server.authorize(deployment, client); err != nil {
+		server.slog.Warnf("Refusing to start deployment %s for %s: %v", deployment.ID, client, err)
+		return err
+	}
+	deployment.StartedBy = client
+	deployment.setState(StateRunning)
+	server.slog.Infof("%s started deployment %s", client, deployment.ID)
+	deployment.notifier.Notify(notify.EventConfirmed, notify.Status(notify.StatusPending), deployment.progress.SuccessfulApplications...)
+
+	go server.run(deployment)
+	return nil
+}
+
+func (server *Server) run(deployment *Deployment) {
+	exitCode := deployment.progress.SwitchApplications()
+
+	deployment.mutex.Lock()
+	deployment.ExitCode = &exitCode
+	server.finish(deployment)
+	if exitCode == 0 {
+		deployment.setState(StateSucceeded)
+	} else {
+		deployment.setState(StateFailed)
+	}
+	deployment.mutex.Unlock()
+	server.slog.Infof("Deployment %s finished with exit code %d", deployment.ID, exitCode)
+}
+
+// Cancel cancels a deployment, which is not running yet
+func (server *Server) Cancel(deployment *Deployment, client string) error {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	switch deployment.State {
+	case StateLoading:
+		// load finishes the deployment, after the applications are loaded
+		deployment.setState(StateCancelled)
+	case StatePlanned:
+		deployment.setState(StateCancelled)
+		server.finish(deployment)
+	default:
+		return &Error{Status: http.StatusConflict, Message: "deployment is " + deployment.State}
+	}
+	server.slog.Infof("%s cancelled deployment %s", client, deployment.ID)
+	return nil
+}
+
+// finish releases the deploy locks, connections and reserved applications of deployment, deployment must be locked
+func (server *Server) finish(deployment *Deployment) {
+	for _, application := range deployment.locked {
+		deployment.locker.Release(application)
+	}
+	deployment.applications.Close()
+
+	server.mutex.Lock()
+	for _, key := range deployment.keys {
+		if server.active[key] == deployment {
+			delete(server.active, key)
+		}
+	}
+	deployment.released = true
+	server.mutex.Unlock()
+
+	p := deployment.progress
+	notifier := deployment.notifier
+	go func() {
+		notifier.Notify(notify.EventRunCompleted, func(application *common.Application) string {
+			for _, failed := range p.FailedApplications {
+				if failed == application {
+					return notify.StatusSkipped
+				}
+			}
+			return notify.ApplicationStatus(application)
+		}, deployment.applications...)
+		notifier.Wait(notificationTimeout)
+	}()
+}
+
+// prune removes the oldest released deployments, which exceed the history, server must be locked
+func (server *Server) prune() {
+	for i := 0; len(server.deployments) > server.history && i < len(server.deployments); {
+		if server.deployments[i].released {
+			server.deployments = append(server.deployments[:i], server.deployments[i+1:]...)
+		} else {
+			i++
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*Error); ok {
+		status = e.Status
+	}
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func randomID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,190 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/notify"
+	"github.com/lscheidler/switchctl/plan"
+)
+
+func newTestServer(t *testing.T, config *conf.Config) *Server {
+	server, err := New(zap.NewNop().Sugar(), config, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// planned returns a loaded deployment of app in prod, which is not registered at the server
+func planned(t *testing.T, createdBy string) *Deployment {
+	notifier, err := notify.New(zap.NewNop().Sugar(), nil, "prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Deployment{
+		ID:           randomID(),
+		Environment:  "prod",
+		Applications: []*plan.Application{{Name: "app", Version: "1.0"}},
+		State:        StatePlanned,
+		CreatedBy:    createdBy,
+		loaded:       true,
+		notifier:     notifier,
+		changed:      make(chan struct{}),
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+
+	tests := []struct {
+		environment string
+		name        string
+		version     string
+		err         string
+	}{
+		{"prod", "app", "1.0.0+build.1", ""},
+		{"prod", "app-web_1.x", "2:1.0~rc1", ""},
+		{"", "app", "1.0", "environment must be set"},
+		{"prod", "", "1.0", "applications require name and version"},
+		{"prod;id", "app", "1.0", `invalid environment "prod;id"`},
+		{"prod", "foo;id", "1.0", `invalid application name "foo;id"`},
+		{"prod", "$(id)", "1.0", `invalid application name "$(id)"`},
+		{"prod", "-app", "1.0", `invalid application name "-app"`},
+		{"prod", "app", "1.0 && id", `invalid version "1.0 && id" of app`},
+		{"prod", "app", "`id`", "invalid version"},
+	}
+	for _, test := range tests {
+		deployment := &Deployment{
+			Environment:  test.environment,
+			Applications: []*plan.Application{{Name: test.name, Version: test.version}},
+			CreatedBy:    "alice",
+		}
+		err := server.check(deployment)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("check(%q, %q, %q) returned unexpected error: %v", test.environment, test.name, test.version, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("check(%q, %q, %q) returned %v, expected %q", test.environment, test.name, test.version, err, test.err)
+		} else if e, ok := err.(*Error); !ok || e.Status != http.StatusBadRequest {
+			t.Errorf("check(%q, %q, %q) returned %#v, expected status %d", test.environment, test.name, test.version, err, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCheckUnsupportedStrategy(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+	deployment := planned(t, "alice")
+	deployment.Strategy = "parallel"
+	if err := server.check(deployment); err == nil || !strings.Contains(err.Error(), `unsupported strategy "parallel"`) {
+		t.Errorf("check returned %v, expected unsupported strategy", err)
+	}
+}
+
+func TestStartRequiresPlanned(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+
+	for _, state := range []string{StateLoading, StateRunning, StateSucceeded, StateFailed, StateCancelled} {
+		deployment := planned(t, "alice")
+		deployment.State = state
+		err := server.Start(deployment, "alice")
+		if e, ok := err.(*Error); !ok || e.Status != http.StatusConflict {
+			t.Errorf("Start of %s deployment returned %v, expected status %d", state, err, http.StatusConflict)
+		}
+		if deployment.State != state || deployment.StartedBy != "" {
+			t.Errorf("Start of %s deployment changed state to %s, started by %q", state, deployment.State, deployment.StartedBy)
+		}
+	}
+}
+
+func TestStartChecksAccessPolicyOfClient(t *testing.T) {
+	server := newTestServer(t, &conf.Config{
+		Access: []*conf.AccessRule{{Environments: []string{"prod"}, Users: []string{"alice"}}},
+	})
+	deployment := planned(t, "alice")
+
+	err := server.Start(deployment, "mallory")
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusForbidden || !strings.Contains(err.Error(), "access denied for mallory") {
+		t.Errorf("Start returned %v, expected access denied for mallory", err)
+	}
+	if deployment.State != StatePlanned || deployment.StartedBy != "" {
+		t.Errorf("refused Start changed state to %s, started by %q", deployment.State, deployment.StartedBy)
+	}
+}
+
+func TestStartChecksFreezes(t *testing.T) {
+	server := newTestServer(t, &conf.Config{
+		Freezes: []*conf.Freeze{{Environments: []string{"prod"}, Reason: "release freeze", From: "2000-01-01"}},
+	})
+	deployment := planned(t, "alice")
+
+	err := server.Start(deployment, "alice")
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusConflict || !strings.Contains(err.Error(), "release freeze") {
+		t.Errorf("Start returned %v, expected active freeze window", err)
+	}
+	if deployment.State != StatePlanned {
+		t.Errorf("refused Start changed state to %s", deployment.State)
+	}
+}
+
+func TestStartRequiresApprovedPlan(t *testing.T) {
+	server := newTestServer(t, &conf.Config{
+		Approvals: []*conf.Approval{{Environments: []string{"prod"}, Required: 1}},
+	})
+	deployment := planned(t, "alice")
+
+	if err := server.Start(deployment, "alice"); err == nil || !strings.Contains(err.Error(), "an approved plan is required") {
+		t.Errorf("Start returned %v, expected an approved plan to be required", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+
+	deployment := planned(t, "alice")
+	if err := server.Cancel(deployment, "alice"); err != nil {
+		t.Fatalf("Cancel of planned deployment returned %v", err)
+	}
+	if deployment.State != StateCancelled || !deployment.released {
+		t.Errorf("cancelled deployment is %s, released %v", deployment.State, deployment.released)
+	}
+	if err := server.Start(deployment, "alice"); err == nil {
+		t.Error("Start of cancelled deployment succeeded")
+	}
+	if err := server.Cancel(deployment, "alice"); err == nil {
+		t.Error("Cancel of cancelled deployment succeeded")
+	}
+
+	// loading deployments are finished by load, after the applications are loaded
+	deployment = planned(t, "alice")
+	deployment.State = StateLoading
+	deployment.loaded = false
+	if err := server.Cancel(deployment, "alice"); err != nil {
+		t.Fatalf("Cancel of loading deployment returned %v", err)
+	}
+	if deployment.State != StateCancelled || deployment.released {
+		t.Errorf("cancelled loading deployment is %s, released %v", deployment.State, deployment.released)
+	}
+}
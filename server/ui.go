@@ -0,0 +1,209 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/lscheidler/switchctl/common"
+	"github.com/lscheidler/switchctl/policy"
+)
+
+const sessionCookie = "switchctl_session"
+
+// ConfiguredApplication is an application of the config with the environments it is configured in
+type ConfiguredApplication struct {
+	Name         string   `json:"name"`
+	Regexp       bool     `json:"regexp,omitempty"`
+	Environments []string `json:"environments"`
+}
+
+// Configured lists environments and applications of the config
+type Configured struct {
+	Environments []string                 `json:"environments"`
+	Applications []*ConfiguredApplication `json:"applications"`
+}
+
+// InstanceVersion is the current version of an application on an instance
+type InstanceVersion struct {
+	Hostname string `json:"hostname"`
+	Current  string `json:"current"`
+	Error    string `json:"error,omitempty"`
+}
+
+// EnableUI serves the web ui for user and returns a one-time token to log in with.
+// user is a local user, its groups are matched by groups of access rules.
+func (server *Server) EnableUI(user string) string {
+	groups, err := policy.LookupGroups(user)
+	if err != nil {
+		server.slog.Warnf("Failed to look up groups of %s: %v", user, err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	token := randomID() + randomID()
+	server.ui = true
+	server.logins[token] = user
+	server.groups[user] = groups
+	return token
+}
+
+func (server *Server) handleUI(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/" {
+			http.NotFound(w, request)
+		} else if server.Client(request) == "" {
+			http.Error(w, "not logged in, use the login url printed by switchctl ui", http.StatusUnauthorized)
+		} else {
+			writeAsset(w, "text/html; charset=utf-8", indexHTML)
+		}
+	})
+	mux.HandleFunc("/app.js", func(w http.ResponseWriter, request *http.Request) {
+		writeAsset(w, "application/javascript", appJS)
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, request *http.Request) {
+		writeAsset(w, "text/css", styleCSS)
+	})
+	mux.HandleFunc("/login", server.handleLogin)
+	mux.HandleFunc("/applications", server.authenticated(server.handleApplications))
+	mux.HandleFunc("/versions", server.authenticated(server.handleVersions))
+}
+
+// handleLogin exchanges a one-time token for a session cookie
+func (server *Server) handleLogin(w http.ResponseWriter, request *http.Request) {
+	token := request.URL.Query().Get("token")
+
+	server.mutex.Lock()
+	user, ok := server.logins[token]
+	if ok {
+		delete(server.logins, token)
+	}
+	session := randomID() + randomID()
+	if ok {
+		server.sessions[session] = user
+	}
+	server.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, "invalid or already used login token", http.StatusUnauthorized)
+		return
+	}
+
+	server.slog.Infof("%s logged in to web ui from %s", user, request.RemoteAddr)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, request, "/", http.StatusSeeOther)
+}
+
+// handleApplications lists the configured environments and applications
+func (server *Server) handleApplications(w http.ResponseWriter, request *http.Request, client string) {
+	environments := map[string]bool{}
+	applications := map[string]*ConfiguredApplication{}
+	for _, entry := range server.config.Entries {
+		for _, environment := range entry.Environments {
+			environments[environment] = true
+		}
+		for _, a := range entry.Applications {
+			names := map[string]bool{}
+			if a.Name != "" {
+				names[a.Name] = false
+			}
+			if a.Alias != nil {
+				names[*a.Alias] = false
+			}
+			if a.Regexp != "" {
+				names[a.Regexp] = true
+			}
+			for name, regexp := range names {
+				application, ok := applications[name]
+				if !ok {
+					application = &ConfiguredApplication{Name: name, Regexp: regexp}
+					applications[name] = application
+				}
+				application.Environments = append(application.Environments, entry.Environments...)
+			}
+		}
+	}
+
+	result := &Configured{Environments: []string{}, Applications: []*ConfiguredApplication{}}
+	for environment := range environments {
+		result.Environments = append(result.Environments, environment)
+	}
+	sort.Strings(result.Environments)
+	for _, application := range applications {
+		result.Applications = append(result.Applications, application)
+	}
+	sort.Slice(result.Applications, func(i, j int) bool {
+		return result.Applications[i].Name < result.Applications[j].Name
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleVersions returns the current versions of an application on its instances in an environment
+func (server *Server) handleVersions(w http.ResponseWriter, request *http.Request, client string) {
+	environment := request.URL.Query().Get("environment")
+	name := request.URL.Query().Get("application")
+	if environment == "" || name == "" {
+		writeError(w, &Error{Status: http.StatusBadRequest, Message: "environment and application must be set"})
+		return
+	} else if !namePattern.MatchString(environment) {
+		writeError(w, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid environment %q", environment)})
+		return
+	} else if !namePattern.MatchString(name) {
+		writeError(w, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid application name %q", name)})
+		return
+	}
+
+	// resolving connects to the instances, so only users and groups of the access policy are checked like for locks
+	if err := policy.Check(server.config.Access, &policy.Request{User: client, Groups: server.clientGroups(client), Environment: environment, Applications: []string{name}, LocksOnly: true}); err != nil {
+		writeError(w, &Error{Status: http.StatusForbidden, Message: err.Error()})
+		return
+	}
+
+	application := common.NewApplication(name, "")
+	defer application.Close()
+	err := application.GetInstances(server.slog, server.config, environment, true)
+
+	result := []*InstanceVersion{}
+	for _, instance := range append(append([]*common.Instance{}, application.SuccessfulInstances...), application.FailedInstances...) {
+		version := &InstanceVersion{Hostname: instance.Hostname(), Current: instance.CurrentVersion().String()}
+		if messages := errorMessages(instance.Errors); len(messages) > 0 {
+			version.Error = messages[0]
+		} else if err := instance.ConnectError(); err != nil {
+			version.Error = err.Error()
+		}
+		result = append(result, version)
+	}
+	if err != nil && len(result) == 0 {
+		writeError(w, &Error{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeAsset(w http.ResponseWriter, contentType string, content string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(content))
+}
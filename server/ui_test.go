@@ -0,0 +1,114 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/user"
+	"strings"
+	"testing"
+
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/policy"
+)
+
+// get requests path with a session of user
+func get(server *Server, user string, path string) *httptest.ResponseRecorder {
+	server.EnableUI(user)
+	server.sessions["session"] = user
+
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.AddCookie(&http.Cookie{Name: sessionCookie, Value: "session"})
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestHandleVersionsNames(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+
+	tests := []struct {
+		environment string
+		application string
+		err         string
+	}{
+		{"prod", "foo;id", `invalid application name \"foo;id\"`},
+		{"prod", "$(id)", `invalid application name`},
+		{"prod", "foo bar", `invalid application name`},
+		{"prod;id", "foo", `invalid environment \"prod;id\"`},
+		{"", "foo", "environment and application must be set"},
+	}
+	for _, test := range tests {
+		query := url.Values{"environment": {test.environment}, "application": {test.application}}
+		recorder := get(server, "alice", "/versions?"+query.Encode())
+		if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), test.err) {
+			t.Errorf("versions of %q in %q returned %d %s, expected %d %s", test.application, test.environment, recorder.Code, recorder.Body, http.StatusBadRequest, test.err)
+		}
+	}
+}
+
+func TestHandleVersionsAccessPolicy(t *testing.T) {
+	server := newTestServer(t, &conf.Config{
+		Access: []*conf.AccessRule{{Environments: []string{"prod"}, Users: []string{"bob"}}},
+	})
+
+	recorder := get(server, "alice", "/versions?environment=prod&application=foo")
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "access denied for alice") {
+		t.Errorf("versions returned %d %s, expected %d access denied", recorder.Code, recorder.Body, http.StatusForbidden)
+	}
+}
+
+func TestHandleVersionsNotLoggedIn(t *testing.T) {
+	server := newTestServer(t, &conf.Config{})
+	server.EnableUI("alice")
+
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/versions?environment=prod&application=foo", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("versions without session returned %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandleVersionsGroupsOfSession(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	groups, err := policy.LookupGroups(current.Username)
+	if err != nil || len(groups) == 0 {
+		t.Skipf("no groups of %s: %v", current.Username, err)
+	}
+
+	server := newTestServer(t, &conf.Config{
+		Access: []*conf.AccessRule{{Environments: []string{"prod"}, Groups: groups[:1]}},
+	})
+	recorder := get(server, current.Username, "/versions?environment=prod&application=foo")
+	if recorder.Code == http.StatusForbidden {
+		t.Errorf("versions of session of %s in group %s returned %d %s", current.Username, groups[0], recorder.Code, recorder.Body)
+	}
+
+	// api tokens have no groups
+	server.tokens = []*conf.Token{{Name: "ci", Token: "secret"}}
+	request := httptest.NewRequest(http.MethodGet, "/versions?environment=prod&application=foo", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("versions of token returned %d %s, expected %d", recorder.Code, recorder.Body, http.StatusForbidden)
+	}
+}
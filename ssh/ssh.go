@@ -16,10 +16,12 @@
 package ssh
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -42,6 +44,61 @@ func New(hostname string, username string, port string) *Ssh {
 	}
 }
 
+// AgentSign signs data in namespace with a key of ssh-agent(1), which matches key by SHA256 fingerprint or comment,
+// or with the first key, if key is empty. It returns the public key in authorized_keys format and the signature
+// in the armored SSHSIG format of ssh-keygen(1) -Y sign.
+func AgentSign(data []byte, key string, namespace string) (string, []byte, error) {
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	agentClient := agent.NewClient(conn)
+	keys, err := agentClient.List()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, k := range keys {
+		if key == "" || key == k.Comment || key == ssh.FingerprintSHA256(k) {
+			var flags agent.SignatureFlags
+			if k.Type() == ssh.KeyAlgoRSA {
+				flags = agent.SignatureFlagRsaSha512
+			}
+			signature, err := agentClient.SignWithFlags(k, signedData(data, namespace), flags)
+			if err != nil {
+				return "", nil, err
+			}
+			return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k))), armor(k, namespace, signature), nil
+		}
+	}
+
+	if key == "" {
+		return "", nil, errors.New("no keys found in ssh-agent")
+	}
+	return "", nil, errors.New("key " + key + " not found in ssh-agent")
+}
+
+// AgentKeys returns the public keys of ssh-agent(1) in authorized_keys format
+func AgentKeys() ([]string, error) {
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, k := range keys {
+		result = append(result, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k))))
+	}
+	return result, nil
+}
+
 func (s *Ssh) Connect() error {
 	// ssh-agent(1) provides a UNIX socket at $SSH_AUTH_SOCK.
 	socket := os.Getenv("SSH_AUTH_SOCK")
@@ -62,7 +119,7 @@ func (s *Ssh) Connect() error {
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
-	if sshc, err := ssh.Dial("tcp", s.hostname+":"+s.port, config); err != nil {
+	if sshc, err := ssh.Dial("tcp", net.JoinHostPort(s.hostname, s.port), config); err != nil {
 		//log.Println("Failed to create connection: ", err)
 		//log.Fatal("Failed to create connection: ", err)
 		return err
@@ -101,3 +158,13 @@ func (s *Ssh) Execute(command string, stdout io.Writer, stderr io.Writer) error
 		return nil
 	}
 }
+
+// ExitStatus returns the exit status of a remote command error, 0 if err is nil and -1 if it is unknown
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	} else if exitError, ok := err.(*ssh.ExitError); ok {
+		return exitError.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signatures use the SSHSIG format of ssh-keygen(1) -Y sign, see PROTOCOL.sshsig of OpenSSH
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigHash      = "sha512"
+	sshsigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd       = "-----END SSH SIGNATURE-----"
+	sshsigLineWidth = 70
+)
+
+type sshsig struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshsigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// signedData returns the data, which is signed by an SSHSIG signature of message in namespace
+func signedData(message []byte, namespace string) []byte {
+	hash := sha512.Sum512(message)
+	return append([]byte(sshsigMagic), ssh.Marshal(&sshsigSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: sshsigHash,
+		Hash:          hash[:],
+	})...)
+}
+
+// armor returns signature of publicKey in namespace in the armored SSHSIG format
+func armor(publicKey ssh.PublicKey, namespace string, signature *ssh.Signature) []byte {
+	blob := append([]byte(sshsigMagic), ssh.Marshal(&sshsig{
+		Version:       sshsigVersion,
+		PublicKey:     publicKey.Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: sshsigHash,
+		Signature:     ssh.Marshal(signature),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var buffer bytes.Buffer
+	buffer.WriteString(sshsigBegin + "\n")
+	for len(encoded) > sshsigLineWidth {
+		buffer.WriteString(encoded[:sshsigLineWidth] + "\n")
+		encoded = encoded[sshsigLineWidth:]
+	}
+	buffer.WriteString(encoded + "\n" + sshsigEnd + "\n")
+	return buffer.Bytes()
+}
+
+// unarmor parses an armored SSHSIG signature
+func unarmor(data []byte) (*sshsig, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, sshsigBegin) || !strings.HasSuffix(text, sshsigEnd) {
+		return nil, errors.New("invalid signature: missing SSH SIGNATURE armor")
+	}
+	text = strings.Join(strings.Fields(strings.TrimSuffix(strings.TrimPrefix(text, sshsigBegin), sshsigEnd)), "")
+	blob, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshsigMagic)) {
+		return nil, errors.New("invalid signature: missing SSHSIG magic")
+	}
+	var sig sshsig
+	if err := ssh.Unmarshal(blob[len(sshsigMagic):], &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	if sig.Version != sshsigVersion {
+		return nil, fmt.Errorf("invalid signature: unsupported version %d", sig.Version)
+	}
+	return &sig, nil
+}
+
+// Verify verifies the armored SSHSIG signature of data in namespace with publicKey in authorized_keys format
+func Verify(publicKey string, data []byte, namespace string, signature []byte) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return err
+	}
+
+	sig, err := unarmor(signature)
+	if err != nil {
+		return err
+	}
+	if sig.Namespace != namespace {
+		return fmt.Errorf("signature namespace %q doesn't match %q", sig.Namespace, namespace)
+	} else if sig.HashAlgorithm != sshsigHash {
+		return fmt.Errorf("unsupported signature hash algorithm %q", sig.HashAlgorithm)
+	} else if !bytes.Equal(sig.PublicKey, key.Marshal()) {
+		return errors.New("signature was made with a different key")
+	}
+
+	var s ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &s); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	// SHA-1 RSA signatures aren't allowed in SSHSIG signatures
+	if s.Format == ssh.KeyAlgoRSA {
+		return errors.New("ssh-rsa signatures are not supported, use rsa-sha2-512")
+	}
+	return key.Verify(signedData(data, namespace), &s)
+}
@@ -0,0 +1,181 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fixtures are created with ssh-keygen -Y sign -n switchctl-test of message
+const (
+	message   = "switchctl signed message\n"
+	namespace = "switchctl-test"
+
+	ed25519Key       = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE1wx5NKnFIW3tzksqPSvK+jGvmIrprJ4MXoyWpJC+f0 alice"
+	ed25519Signature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgTXDHk0qcUhbe3OSyo9K8r6Ma+Y
+iumsngxejJakkL5/QAAAAOc3dpdGNoY3RsLXRlc3QAAAAAAAAABnNoYTUxMgAAAFMAAAAL
+c3NoLWVkMjU1MTkAAABAOgsr5vXHz/sjftQx3lj4P17ZfKPmdjkKDB2CKizEwovGo0aaep
+H1KWKU+Tg3zAhi1vEem5AcX0bNAqyiIjAsAw==
+-----END SSH SIGNATURE-----
+`
+
+	rsaKey       = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCkUd3aYVypaw4Bbp1nEQzskaKihxNL8+tbNTrkul8SCv3JVE1JFCy+bTtHgguh8W00HGX14jGEKkTUjXE+JgYoFjrZs+J2gvZ6WiEYGi9mqrzWCaPjVaMywaCOOdTqM0KXI2GfJ36K1oyepiEDUVccpbC1UfJNlc5LDlOdj0cNY0QayC580XsYINrPF5CVbXfMsUW2++Yh0dpdmy2I5/KaJ7sIjbM3zDFMcJ9X6ShNfIXdiVNqDiJ+h+DNk83NJ3WPWz57eaLtTZZFHj33uQGcZoTmtNEcX8h6iqysSeL2dOVJKHD9WY0woLxRVzV4U0tiDIWyVICV2gGJrUKQq6dJ bob"
+	rsaSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAARcAAAAHc3NoLXJzYQAAAAMBAAEAAAEBAKRR3dphXKlrDgFunWcRDO
+yRoqKHE0vz61s1OuS6XxIK/clUTUkULL5tO0eCC6HxbTQcZfXiMYQqRNSNcT4mBigWOtmz
+4naC9npaIRgaL2aqvNYJo+NVozLBoI451OozQpcjYZ8nforWjJ6mIQNRVxylsLVR8k2Vzk
+sOU52PRw1jRBrILnzRexgg2s8XkJVtd8yxRbb75iHR2l2bLYjn8ponuwiNszfMMUxwn1fp
+KE18hd2JU2oOIn6H4M2Tzc0ndY9bPnt5ou1NlkUePfe5AZxmhOa00RxfyHqKrKxJ4vZ05U
+kocP1ZjTCgvFFXNXhTS2IMhbJUgJXaAYmtQpCrp0kAAAAOc3dpdGNoY3RsLXRlc3QAAAAA
+AAAABnNoYTUxMgAAARQAAAAMcnNhLXNoYTItNTEyAAABAExQ2+J5fJBgYQVKax4sFIbS+l
+K5RXkG3qh1WF6273/wDICSthn5jNFWQn5ERomQIeyavDfdUQ2YvJ+EhT6y9/wisK5JMnCr
+2OQAss8otH0L15TugMzWhsCZpMtZ3kO4o+J/Bq761OauyzTQUw3t1XFglVk7Q3Re9us2o2
+Sh1b1LfYjB3nFFZDxiqzBKEIOm47FX6SnWNNeBBUyC7/DMu7nX96PWuvD+hNm2Ahoz5yX/
+UIGx97WRGzp1wMZHuq4xMIf6YKTfXV7ZXi9CLi40J1WtzteqQeJPM2VtFr9H1HRN5BSGhJ
+jCSxlBCbodsB/z3IP3B4/aIkhaIH3P+RQydS8=
+-----END SSH SIGNATURE-----
+`
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		message   string
+		namespace string
+		signature string
+		err       string
+	}{
+		{"ed25519", ed25519Key, message, namespace, ed25519Signature, ""},
+		{"rsa-sha2-512", rsaKey, message, namespace, rsaSignature, ""},
+		{"wrong namespace", ed25519Key, message, "switchctl-config", ed25519Signature, `signature namespace "switchctl-test" doesn't match "switchctl-config"`},
+		{"tampered message", ed25519Key, strings.Replace(message, "signed", "forged", 1), namespace, ed25519Signature, "signature did not verify"},
+		{"tampered rsa message", rsaKey, message + "\n", namespace, rsaSignature, "verification error"},
+		{"different key", rsaKey, message, namespace, ed25519Signature, "signature was made with a different key"},
+		{"missing armor", ed25519Key, message, namespace, strings.TrimPrefix(ed25519Signature, sshsigBegin), "missing SSH SIGNATURE armor"},
+		{"invalid key", "ssh-ed25519 invalid", message, namespace, ed25519Signature, "ssh: no key found"},
+	}
+	for _, test := range tests {
+		err := Verify(test.key, []byte(test.message), test.namespace, []byte(test.signature))
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: returned %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestVerifyRejectsSHA1RSA(t *testing.T) {
+	sig, err := unarmor([]byte(rsaSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &s); err != nil {
+		t.Fatal(err)
+	}
+	s.Format = ssh.KeyAlgoRSA
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(rsaKey, []byte(message), namespace, armor(key, namespace, &s))
+	if err == nil || !strings.Contains(err.Error(), "ssh-rsa signatures are not supported") {
+		t.Errorf("Verify returned %v, expected ssh-rsa signatures to be rejected", err)
+	}
+}
+
+// sign returns an armored SSHSIG signature of message in namespace with a new ed25519 key and the public key
+func sign(t *testing.T, message []byte, namespace string) (string, []byte) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signer.Sign(rand.Reader, signedData(message, namespace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), armor(signer.PublicKey(), namespace, signature)
+}
+
+func TestArmor(t *testing.T) {
+	key, signature := sign(t, []byte(message), namespace)
+	if err := Verify(key, []byte(message), namespace, signature); err != nil {
+		t.Errorf("Verify of armored signature returned %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(signature)), "\n") {
+		if len(line) > sshsigLineWidth {
+			t.Errorf("armored signature has line longer than %d characters: %s", sshsigLineWidth, line)
+		}
+	}
+}
+
+// TestArmorSSHKeygen verifies signatures with ssh-keygen -Y verify
+func TestArmorSSHKeygen(t *testing.T) {
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+	dir, err := ioutil.TempDir("", "sshsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, signature := sign(t, []byte(message), namespace)
+	allowedSigners := filepath.Join(dir, "allowed_signers")
+	if err := ioutil.WriteFile(allowedSigners, []byte("alice "+key+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signatureFile := filepath.Join(dir, "message.sig")
+	if err := ioutil.WriteFile(signatureFile, signature, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		namespace string
+		message   string
+		ok        bool
+	}{
+		{namespace, message, true},
+		{"switchctl-config", message, false},
+		{namespace, message + "tampered", false},
+	} {
+		cmd := exec.Command(sshKeygen, "-Y", "verify", "-f", allowedSigners, "-I", "alice", "-n", test.namespace, "-s", signatureFile)
+		cmd.Stdin = strings.NewReader(test.message)
+		output, err := cmd.CombinedOutput()
+		if (err == nil) != test.ok {
+			t.Errorf("ssh-keygen -Y verify -n %s of %q returned %v, expected success %v: %s", test.namespace, test.message, err, test.ok, output)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Tracer records the spans of a single trace
+type Tracer struct {
+	service string
+	traceID string
+
+	mutex sync.Mutex
+	spans []*Span
+}
+
+// Span is a timed operation of a trace, all methods can be called on a nil span
+type Span struct {
+	tracer *Tracer
+
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+
+	mutex sync.Mutex
+}
+
+func NewTracer(service string) *Tracer {
+	return &Tracer{
+		service: service,
+		traceID: randomID(16),
+	}
+}
+
+func (tracer *Tracer) TraceID() string {
+	return tracer.traceID
+}
+
+// StartSpan starts a new root span
+func (tracer *Tracer) StartSpan(name string) *Span {
+	return tracer.startSpan(name, "")
+}
+
+func (tracer *Tracer) startSpan(name string, parentID string) *Span {
+	span := &Span{
+		tracer:     tracer,
+		SpanID:     randomID(8),
+		ParentID:   parentID,
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+
+	tracer.mutex.Lock()
+	tracer.spans = append(tracer.spans, span)
+	tracer.mutex.Unlock()
+	return span
+}
+
+// Spans returns all spans, unfinished spans are finished
+func (tracer *Tracer) Spans() []*Span {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	for _, span := range tracer.spans {
+		span.Finish()
+	}
+	return append([]*Span{}, tracer.spans...)
+}
+
+// StartSpan starts a new child span
+func (span *Span) StartSpan(name string) *Span {
+	if span == nil {
+		return nil
+	}
+	return span.tracer.startSpan(name, span.SpanID)
+}
+
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.Attributes[key] = value
+}
+
+// SetError marks span as failed, if err is not nil
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.Error = err.Error()
+}
+
+// Finish ends span, if it is not already ended
+func (span *Span) Finish() {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	if span.End.IsZero() {
+		span.End = time.Now()
+	}
+}
+
+// Finished returns true, if span is ended or nil
+func (span *Span) Finished() bool {
+	if span == nil {
+		return true
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	return !span.End.IsZero()
+}
+
+func randomID(length int) string {
+	id := make([]byte, length)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
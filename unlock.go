@@ -0,0 +1,57 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/agtorre/gocolorize"
+
+	"github.com/lscheidler/switchctl/cli"
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/lock"
+)
+
+// unlock removes the deploy locks of applications on all their instances
+func unlock(args *cli.Arguments, config *conf.Config) int {
+	cyellow := gocolorize.Colorize{Fg: gocolorize.Yellow}
+	cred := gocolorize.Colorize{Fg: gocolorize.Red}
+
+	locker := lock.New(slog, config.Lock, args.Environment)
+
+	exitCode := 0
+	for _, application := range args.Applications {
+		fmt.Printf("  - name:       %s\n", cyellow.Paint(application.Name))
+		if err := application.GetInstances(slog, config, args.Environment, args.Dryrun); err != nil {
+			fmt.Printf("    errors:     %v\n", application.Errors)
+			exitCode = 1
+		}
+
+		for _, instance := range application.SuccessfulInstances {
+			holder, err := locker.Unlock(application, instance)
+			if err != nil {
+				fmt.Printf("    - hostname: %s\n      error:    %v\n", cred.Paint(instance.Hostname()), err)
+				exitCode = 1
+			} else if holder != nil {
+				slog.Warnf("%s[%s]: removed lock of %s", application.Name, instance.Hostname(), holder.String())
+				fmt.Printf("    - hostname: %s\n      removed:  lock of %s\n", cyellow.Paint(instance.Hostname()), holder.String())
+			} else {
+				fmt.Printf("    - hostname: %s\n      removed:  no lock found\n", cyellow.Paint(instance.Hostname()))
+			}
+		}
+	}
+	return exitCode
+}
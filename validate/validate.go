@@ -0,0 +1,525 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package validate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	cryptossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+
+	"github.com/lscheidler/switchctl/conf"
+	"github.com/lscheidler/switchctl/freeze"
+	"github.com/lscheidler/switchctl/notify"
+	"github.com/lscheidler/switchctl/policy"
+	"github.com/lscheidler/switchctl/reconcile"
+)
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// yamlUnmarshalError is the prefix of decoding errors, the position is reported with the node
+var yamlUnmarshalError = regexp.MustCompile(`^yaml: unmarshal errors:\n\s*(line \d+: )?`)
+
+// yaml11Booleans are decoded as booleans by gopkg.in/yaml.v2, which loads the config
+var yaml11Booleans = map[string]bool{"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true}
+
+// Error is a validation error at a position of a config file
+type Error struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *Error) Error() string {
+	if err.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", err.File, err.Line, err.Column, err.Message)
+	} else if err.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Message)
+	}
+	return fmt.Sprintf("%s: %s", err.File, err.Message)
+}
+
+// sections can only be defined in one of the config files
+var sections = []string{"metrics", "tracing", "lock", "server"}
+
+type validator struct {
+	file     string
+	files    map[string]int
+	loaded   map[string]bool
+	sections map[string]string
+	errors   []*Error
+	entries  []*entry
+}
+
+// entry is a config entry with the applications, which are matched by name, alias or regexp
+type entry struct {
+	file         string
+	node         *yaml.Node
+	environments []string
+	names        []*yaml.Node
+	regexps      []*yaml.Node
+}
+
+// File validates the config file filename, the files of its includes and the files of the conf.d directory
+func File(filename string) ([]*Error, error) {
+	v := newValidator()
+	if err := v.load(filename); err != nil {
+		return nil, err
+	}
+
+	files, err := conf.DirectoryFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := v.load(file); err != nil {
+			return nil, err
+		}
+	}
+	return v.finish(), nil
+}
+
+// Validate strictly decodes the config data, unknown fields are errors, and checks regular expressions, templates,
+// notifications, freeze windows, access rules, approvals and applications, which match multiple entries
+func Validate(filename string, data []byte) []*Error {
+	v := newValidator()
+	v.validate(filename, data)
+	return v.finish()
+}
+
+func newValidator() *validator {
+	return &validator{
+		files:    map[string]int{},
+		loaded:   map[string]bool{},
+		sections: map[string]string{},
+	}
+}
+
+// load validates the config file filename, if it wasn't validated yet
+func (v *validator) load(filename string) error {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	} else if v.loaded[absolute] {
+		return nil
+	}
+	v.loaded[absolute] = true
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	v.validate(filename, data)
+	return nil
+}
+
+func (v *validator) validate(filename string, data []byte) {
+	previous := v.file
+	defer func() { v.file = previous }()
+	v.file = filename
+	if _, ok := v.files[filename]; !ok {
+		v.files[filename] = len(v.files)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		message := err.Error()
+		if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			v.errors = append(v.errors, &Error{File: filename, Line: line, Message: match[2]})
+		} else {
+			v.errors = append(v.errors, &Error{File: filename, Message: message})
+		}
+		return
+	}
+	if len(root.Content) == 0 {
+		return
+	}
+
+	document := root.Content[0]
+	if document.Kind == yaml.SequenceNode {
+		// config format with a list of entries only
+		v.walk(document, reflect.TypeOf([]*conf.ConfigEntry{}))
+		return
+	}
+	v.walk(document, reflect.TypeOf(conf.Config{}))
+	if document.Kind != yaml.MappingNode {
+		return
+	}
+
+	for _, section := range sections {
+		if node := valueNode(document, section); node != document {
+			if source, ok := v.sections[section]; ok {
+				v.errorf(node, "%s is already defined in %s", section, source)
+			} else {
+				v.sections[section] = filename
+			}
+		}
+	}
+
+	if node := valueNode(document, "environments"); node.Kind == yaml.MappingNode && node != document {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i]
+			if source, ok := v.sections["environments."+name.Value]; ok && source != filename {
+				v.errorf(name, "environment %s is already defined in %s", name.Value, source)
+			} else {
+				v.sections["environments."+name.Value] = filename
+			}
+		}
+	}
+
+	if node := valueNode(document, "include"); node != document {
+		var patterns []string
+		if err := node.Decode(&patterns); err != nil {
+			// type errors are reported by walk
+			return
+		}
+		includes, err := conf.Includes(filename, patterns)
+		if err != nil {
+			v.errorf(node, "%v", err)
+		}
+		for _, include := range includes {
+			if err := v.load(include); err != nil {
+				v.errorf(node, "include %v", err)
+			}
+		}
+	}
+}
+
+// finish checks the entries of all config files and returns the errors sorted by file and position
+func (v *validator) finish() []*Error {
+	v.checkEntries()
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		a, b := v.errors[i], v.errors[j]
+		if a.File != b.File {
+			return v.files[a.File] < v.files[b.File]
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.errors
+}
+
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{File: v.file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// walk checks, that node can be decoded into a value of type t
+func (v *validator) walk(node *yaml.Node, t reflect.Type) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, "expected mapping for %s", typeName(t))
+			return
+		}
+		fields := yamlFields(t)
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if seen[key.Value] {
+				v.errorf(key, "duplicate field %q", key.Value)
+			}
+			seen[key.Value] = true
+
+			field, ok := fields[key.Value]
+			if !ok {
+				v.errorf(key, "unknown field %q in %s, expected one of %s", key.Value, typeName(t), strings.Join(fieldNames(fields), ", "))
+				continue
+			}
+			v.walk(value, field.Type)
+		}
+		v.check(node, t)
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(node, "expected list")
+			return
+		}
+		for _, item := range node.Content {
+			v.walk(item, t.Elem())
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, "expected mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.walk(node.Content[i+1], t.Elem())
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(node, "expected %s value", t.Kind())
+			return
+		}
+		if t.Kind() == reflect.String && node.Style == 0 && yaml11Booleans[strings.ToLower(node.Value)] {
+			v.errorf(node, "unquoted %s is a boolean in YAML 1.1, quote it", node.Value)
+		} else if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.errorf(node, "%s", yamlUnmarshalError.ReplaceAllString(err.Error(), ""))
+		}
+	}
+}
+
+// check checks the values of a mapping node, which was decoded into type t
+func (v *validator) check(node *yaml.Node, t reflect.Type) {
+	value := reflect.New(t)
+	if err := node.Decode(value.Interface()); err != nil {
+		// type errors are reported by walk
+		return
+	}
+
+	switch item := value.Interface().(type) {
+	case *conf.ConfigEntry:
+		v.addEntry(node, item)
+	case *conf.Application:
+		if item.Name == "" && item.Regexp == "" {
+			v.errorf(node, "name or regexp must be set")
+		}
+		if item.Regexp != "" {
+			if _, err := regexp.Compile(item.Regexp); err != nil {
+				v.errorf(valueNode(node, "regexp"), "invalid regexp: %v", err)
+			}
+		}
+	case *conf.Instance:
+		if err := item.Check(); err != nil {
+			v.errorf(node, "%v", err)
+		}
+		if hosts := valueNode(node, "hosts"); hosts.Kind == yaml.SequenceNode {
+			for _, host := range hosts.Content {
+				if _, err := conf.ParseTemplate("host", host.Value); err != nil {
+					v.errorf(host, "invalid template: %v", err)
+				}
+			}
+		}
+		for _, key := range []string{"template", "user", "port", "srv", "addresses"} {
+			if value := valueNode(node, key); value != node {
+				if _, err := conf.ParseTemplate(key, value.Value); err != nil && key == "template" {
+					v.errorf(value, "invalid template: %v", err)
+				} else if err != nil {
+					v.errorf(value, "invalid %s template: %v", key, err)
+				}
+			}
+		}
+	case *conf.Inventory:
+		for _, key := range []string{"file", "group"} {
+			if value := valueNode(node, key); value != node {
+				if _, err := conf.ParseTemplate(key, value.Value); err != nil {
+					v.errorf(value, "invalid %s template: %v", key, err)
+				}
+			}
+		}
+	case *conf.Command:
+		if value := valueNode(node, "command"); value != node {
+			if _, err := conf.ParseCommandTemplate("command", value.Value); err != nil {
+				v.errorf(value, "invalid command template: %v", err)
+			}
+		}
+	case *conf.DNS:
+		for _, nameserver := range item.Nameservers {
+			host := nameserver
+			if h, _, err := net.SplitHostPort(nameserver); err == nil {
+				host = h
+			}
+			if net.ParseIP(host) == nil {
+				v.errorf(valueNode(node, "nameservers"), "nameserver %s must be an ip address with optional port", nameserver)
+			}
+		}
+		if item.Timeout < 0 {
+			v.errorf(valueNode(node, "timeout"), "timeout must not be negative")
+		}
+	case *conf.Notification:
+		notification := *item
+		notification.Environments = nil
+		if _, err := notify.New(nil, []*conf.Notification{&notification}, "", false); err != nil {
+			v.errorf(node, "%v", err)
+		}
+	case *conf.Freeze:
+		if err := freeze.Validate(item); err != nil {
+			v.errorf(node, "invalid freeze: %v", err)
+		}
+	case *conf.AccessRule:
+		switch item.Confirmation {
+		case "", policy.ConfirmationAny, policy.ConfirmationInteractive, policy.ConfirmationYes:
+		default:
+			v.errorf(valueNode(node, "confirmation"), "unknown confirmation %q, expected one of %s, %s or %s", item.Confirmation, policy.ConfirmationAny, policy.ConfirmationInteractive, policy.ConfirmationYes)
+		}
+	case *conf.Approval:
+		if item.Required > len(item.Approvers) {
+			v.errorf(node, "%d approvals required, but only %d approvers configured", item.Required, len(item.Approvers))
+		}
+	case *conf.Approver:
+		if _, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(item.Key)); err != nil {
+			v.errorf(node, "invalid key of approver %s: %v", item.Name, err)
+		}
+	case *conf.Reconcile:
+		switch item.Mode {
+		case "", reconcile.ModeAuto, reconcile.ModeConfirm, reconcile.ModeApproval, reconcile.ModeReport:
+		default:
+			v.errorf(valueNode(node, "mode"), "unknown reconcile mode %q", item.Mode)
+		}
+	case *conf.Token:
+		if item.Name == "" || item.Token == "" {
+			v.errorf(node, "name and token must be set")
+		}
+	}
+}
+
+func (v *validator) addEntry(node *yaml.Node, item *conf.ConfigEntry) {
+	e := &entry{file: v.file, node: node, environments: item.Environments}
+	if applications := valueNode(node, "applications"); applications.Kind == yaml.SequenceNode {
+		for _, application := range applications.Content {
+			for _, key := range []string{"name", "alias"} {
+				if name := valueNode(application, key); name != application && name.Value != "" {
+					e.names = append(e.names, name)
+				}
+			}
+			if r := valueNode(application, "regexp"); r != application && r.Value != "" {
+				e.regexps = append(e.regexps, r)
+			}
+		}
+	}
+	v.entries = append(v.entries, e)
+}
+
+// checkEntries reports applications, which match multiple entries for the same environment by name, alias or regexp
+func (v *validator) checkEntries() {
+	previous := v.file
+	defer func() { v.file = previous }()
+
+	for j, later := range v.entries {
+		v.file = later.file
+		for _, earlier := range v.entries[:j] {
+			environments := intersection(earlier.environments, later.environments)
+			if len(environments) == 0 {
+				continue
+			}
+			for _, name := range later.names {
+				if other := earlier.match(name.Value); other != nil {
+					v.errorf(name, "application %s in %s already matches entry at %s (%s)", name.Value, strings.Join(environments, ", "), earlier.position(later), other.Value)
+				}
+			}
+			for _, name := range earlier.names {
+				for _, r := range later.regexps {
+					if re, err := regexp.Compile(r.Value); err == nil && re.MatchString(name.Value) {
+						v.errorf(r, "regexp %s in %s matches application %s of entry at %s", r.Value, strings.Join(environments, ", "), name.Value, earlier.position(later))
+					}
+				}
+			}
+		}
+	}
+}
+
+// position returns the line of entry, prefixed with its file, if it is defined in another file than other
+func (e *entry) position(other *entry) string {
+	if e.file != other.file {
+		return fmt.Sprintf("%s:%d", e.file, e.node.Line)
+	}
+	return fmt.Sprintf("line %d", e.node.Line)
+}
+
+// match returns the name or regexp node of entry, which matches application, or nil
+func (e *entry) match(application string) *yaml.Node {
+	for _, name := range e.names {
+		if name.Value == application {
+			return name
+		}
+	}
+	for _, r := range e.regexps {
+		if re, err := regexp.Compile(r.Value); err == nil && re.MatchString(application) {
+			return r
+		}
+	}
+	return nil
+}
+
+// valueNode returns the value of key in a mapping node or node itself, if key doesn't exist
+func valueNode(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return node
+}
+
+// yamlFields returns the fields of struct type t by their yaml name
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func fieldNames(fields map[string]reflect.StructField) []string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typeName(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(conf.Config{}):
+		return "config"
+	case reflect.TypeOf(conf.ConfigEntry{}):
+		return "entry"
+	}
+	return strings.ToLower(t.Name()[:1]) + t.Name()[1:]
+}
+
+func intersection(a []string, b []string) []string {
+	var result []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				result = append(result, x)
+			}
+		}
+	}
+	return result
+}
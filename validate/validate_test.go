@@ -0,0 +1,205 @@
+/*
+  Copyright 2020 Lars Eric Scheidler
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+*/
+package validate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func messages(errors []*Error) []string {
+	result := []string{}
+	for _, err := range errors {
+		result = append(result, err.Error())
+	}
+	return result
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{
+			name: "valid",
+			config: `entries:
+  - applications:
+      - name: app1
+    environments: [staging]
+    instances:
+      - template: app-{{ .InstanceNumber }}.example.com
+        numberOfInstances: 2
+`,
+		},
+		{
+			name: "unknown and duplicate fields",
+			config: `entries:
+  - applications:
+      - name: app1
+        nmae: app2
+    environments: [staging]
+    environments: [production]
+    instances:
+      - template: app
+        numberOfInstances: 1
+`,
+			expected: []string{
+				`config.yml:4:9: unknown field "nmae" in application, expected one of alias, name, regexp`,
+				`config.yml:6:5: duplicate field "environments"`,
+			},
+		},
+		{
+			name: "types and values",
+			config: `entries:
+  - applications:
+      - regexp: "app(["
+    environments: staging
+    instances:
+      - template: app
+        numberOfInstances: two
+      - template: app-{{ .InstanceNumber
+        numberOfInstances: 2
+access:
+  - confirmation: no
+`,
+			expected: []string{
+				"config.yml:3:17: invalid regexp: error parsing regexp: missing closing ]: `[`",
+				"config.yml:4:19: expected list",
+				"config.yml:7:28: cannot unmarshal !!str `two` into int",
+				"config.yml:8:19: invalid template: template: template:1: unclosed action",
+				"config.yml:11:19: unquoted no is a boolean in YAML 1.1, quote it",
+				`config.yml:11:19: unknown confirmation "no", expected one of any, interactive or yes`,
+			},
+		},
+		{
+			name: "duplicate applications",
+			config: `entries:
+  - applications:
+      - name: app1
+      - name: app2
+    environments: [staging, production]
+    instances:
+      - template: app
+        numberOfInstances: 1
+  - applications:
+      - name: app1
+    environments: [production]
+    instances:
+      - template: app
+        numberOfInstances: 1
+  - applications:
+      - regexp: app.*
+    environments: [staging]
+    instances:
+      - template: app
+        numberOfInstances: 1
+  - applications:
+      - name: app1
+    environments: [qa]
+    instances:
+      - template: app
+        numberOfInstances: 1
+`,
+			expected: []string{
+				"config.yml:10:15: application app1 in production already matches entry at line 2 (app1)",
+				"config.yml:16:17: regexp app.* in staging matches application app1 of entry at line 2",
+				"config.yml:16:17: regexp app.* in staging matches application app2 of entry at line 2",
+			},
+		},
+		{
+			name:     "syntax error",
+			config:   "entries:\n  - applications: [\n",
+			expected: []string{"config.yml:2: did not find expected node content"},
+		},
+	}
+
+	for _, test := range tests {
+		errors := messages(Validate("config.yml", []byte(test.config)))
+		if test.expected == nil {
+			test.expected = []string{}
+		}
+		if !reflect.DeepEqual(errors, test.expected) {
+			t.Errorf("%s: Validate returned\n%q\nexpected\n%q", test.name, errors, test.expected)
+		}
+	}
+}
+
+func TestValidateIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.yml": `include:
+  - teams/*.yml
+lock:
+  ttl: 1h
+entries:
+  - applications:
+      - name: app1
+    environments: [staging]
+    instances:
+      - template: app
+        numberOfInstances: 1
+`,
+		"teams/a.yml": `lock:
+  ttl: 2h
+entries:
+  - applications:
+      - name: app1
+    environments: [staging]
+    instances:
+      - template: app
+        numberOfInstances: 1
+`,
+		"teams/b.yml": `entries:
+  - applications:
+      - name: app2
+    environments: [staging]
+    instances:
+      - template: app
+        numberOfInstanzes: 1
+`,
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := filepath.Join(dir, "config.yml")
+	data, _ := ioutil.ReadFile(config)
+	errors := messages(Validate(config, data))
+	expected := []string{
+		filepath.Join(dir, "teams/a.yml") + ":2:3: lock is already defined in " + config,
+		filepath.Join(dir, "teams/a.yml") + ":5:15: application app1 in staging already matches entry at " + config + ":6 (app1)",
+		filepath.Join(dir, "teams/b.yml") + `:6:9: numberOfInstances, instanceNumbers or hosts must be set for template "app", e.g. numberOfInstances: 1 for a single instance`,
+		filepath.Join(dir, "teams/b.yml") + `:7:9: unknown field "numberOfInstanzes" in instance, expected one of addresses, command, hosts, instanceNumbers, inventory, numberOfInstances, numberOfInstancesPerEnvironment, port, reverseInstanceOrder, srv, template, user`,
+	}
+	if !reflect.DeepEqual(errors, expected) {
+		t.Errorf("Validate returned\n%q\nexpected\n%q", errors, expected)
+	}
+}